@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+const (
+	// bootstrapInitialBackoff and bootstrapMaxBackoff bound the wait between bootstrap attempts
+	bootstrapInitialBackoff = 5 * time.Second
+	bootstrapMaxBackoff     = 5 * time.Minute
+	// bootstrapRequeueInterval is how often a reconcile waiting for the bootstrap is retried
+	bootstrapRequeueInterval = 10 * time.Second
+)
+
+var (
+	bootstrapPendingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nginx_lb_operator_bootstrap_pending",
+		Help: "Set to 1 while the leader has not completed the LB host bootstrap, including VRID allocation.",
+	})
+	bootstrapFailuresCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nginx_lb_operator_bootstrap_failures_total",
+		Help: "Number of failed LB host bootstrap attempts.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(bootstrapPendingGauge, bootstrapFailuresCounter)
+}
+
+// bootstrapStep is a startup task that prepares the LB hosts before Services can be reconciled.
+type bootstrapStep struct {
+	name string
+	run  func(ctx context.Context, c client.Client) error
+}
+
+// bootstrapSteps run in order; a step is not repeated once it succeeded.
+var bootstrapSteps = []bootstrapStep{
+	{"VRID allocation", utils.GetOrAllocateVRIDsOnStartup},
+	{"Keepalived include", func(ctx context.Context, c client.Client) error {
+		_, err := utils.EnsureKeepalivedIncludes(ctx, c)
+		return err
+	}},
+	{"NGINX stream include", func(ctx context.Context, c client.Client) error {
+		_, err := utils.EnsureNGINXStreamIncludes(ctx, c)
+		return err
+	}},
+	{"VRRP auth", func(ctx context.Context, c client.Client) error {
+		_, err := utils.GetOrCreateVRRPAuth(ctx, c)
+		return err
+	}},
+}
+
+// Bootstrap allocates the cluster's VRIDs and prepares the LB hosts on the leader, retrying with
+// exponential backoff while an LB host is unreachable instead of failing the operator.
+// Reconcilers that need VRIDs wait for it with Pending. A replica that loses leadership exits,
+// so every new leader runs the bootstrap and re-validates the VRIDs in VRID_allocations.conf
+// and the vrid-allocations ConfigMap before it reconciles.
+type Bootstrap struct {
+	client.Client
+	// Elected is closed when this replica becomes the leader, see manager.Elected
+	Elected <-chan struct{}
+
+	mu      sync.Mutex
+	done    bool
+	step    string
+	lastErr error
+}
+
+// NeedLeaderElection runs the bootstrap on the leader only.
+func (b *Bootstrap) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs the bootstrap steps until all succeeded or the context is cancelled.
+func (b *Bootstrap) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("bootstrap")
+	bootstrapPendingGauge.Set(1)
+	log.Info("Elected leader; validating VRID state and LB hosts before reconciling")
+
+	backoff := bootstrapInitialBackoff
+	for i := 0; i < len(bootstrapSteps); {
+		step := bootstrapSteps[i]
+		b.setState(step.name, nil)
+
+		if err := step.run(ctx, b.Client); err != nil {
+			bootstrapFailuresCounter.Inc()
+			b.setState(step.name, err)
+			log.Error(err, "Bootstrap step failed, retrying", "step", step.name, "backoff", backoff)
+			if err := utils.SleepWithContext(ctx, backoff); err != nil {
+				return nil
+			}
+			backoff = min(backoff*2, bootstrapMaxBackoff)
+			continue
+		}
+
+		log.Info("Bootstrap step completed", "step", step.name)
+		backoff = bootstrapInitialBackoff
+		i++
+	}
+
+	b.mu.Lock()
+	b.done = true
+	b.lastErr = nil
+	b.mu.Unlock()
+	bootstrapPendingGauge.Set(0)
+	log.Info("Bootstrap completed")
+	return nil
+}
+
+// Pending returns a result requeueing the reconcile and true while the bootstrap has not completed.
+func (b *Bootstrap) Pending() (ctrl.Result, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return ctrl.Result{}, false
+	}
+	return ctrl.Result{RequeueAfter: bootstrapRequeueInterval}, true
+}
+
+// ReadyzCheck fails while the leader has not completed the bootstrap. Replicas waiting for
+// leadership are ready, so rolling updates can proceed.
+func (b *Bootstrap) ReadyzCheck(_ *http.Request) error {
+	select {
+	case <-b.Elected:
+	default:
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return nil
+	}
+	switch {
+	case b.lastErr != nil:
+		return fmt.Errorf("bootstrap pending at %s: %w", b.step, b.lastErr)
+	case b.step != "":
+		return fmt.Errorf("bootstrap pending at %s", b.step)
+	default:
+		return fmt.Errorf("bootstrap has not started")
+	}
+}
+
+// setState records the current step and its last error.
+func (b *Bootstrap) setState(step string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.step = step
+	b.lastErr = err
+}
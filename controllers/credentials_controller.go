@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+// CredentialsReconciler watches the NGINX credentials Secret and hot-reloads the SSH credentials.
+// It runs on every replica, as the preflight and readiness checks of standby replicas use the
+// credentials and LB hosts of the Secret as well.
+type CredentialsReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CredentialsReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	secretKey, err := utils.GetCredentialsSecretKey()
+	if err != nil {
+		return err
+	}
+
+	// Only the credentials Secret is of interest
+	isCredentialsSecret := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == secretKey.Name && obj.GetNamespace() == secretKey.Namespace
+	})
+
+	needLeaderElection := false
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("credentials").
+		For(&corev1.Secret{}, builder.WithPredicates(isCredentialsSecret)).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		Complete(r)
+}
+
+// Reconcile validates changed credentials and switches the SSH connections over to them.
+func (r *CredentialsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, req.NamespacedName, secret); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Credentials secret not found; keeping current SSH credentials", "secret", req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	rotated, err := utils.ReloadSSHCredentials(ctx, r.Client)
+	if err != nil {
+		log.Error(err, "Failed to rotate SSH credentials", "secret", req.NamespacedName)
+		if !recordHostError(r.Recorder, secret, err) {
+			r.Recorder.Event(secret, corev1.EventTypeWarning, "CredentialsRotationFailed", err.Error())
+		}
+		// Retry later in case the NGINX server was only temporarily unreachable
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	if rotated {
+		log.Info("Rotated SSH credentials", "secret", req.NamespacedName)
+		r.Recorder.Event(secret, corev1.EventTypeNormal, "CredentialsRotated", "SSH credentials validated and rotated successfully")
+	}
+
+	return ctrl.Result{}, nil
+}
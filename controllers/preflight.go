@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+// PreflightRunner periodically runs the LB host preflight checks, records an event on the
+// credentials Secret whenever a check starts failing or passes again, and fails the readiness
+// check while any check fails.
+type PreflightRunner struct {
+	client.Client
+	Recorder record.EventRecorder
+	Interval time.Duration
+
+	mu  sync.Mutex
+	ran bool
+	err error
+	// failed holds the failure of each failing "host/check"
+	failed map[string]string
+}
+
+// NeedLeaderElection runs the checks on every replica, as each reports its own readiness.
+func (p *PreflightRunner) NeedLeaderElection() bool {
+	return false
+}
+
+// Start runs the checks at startup and repeats them until the context is cancelled.
+func (p *PreflightRunner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		p.run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs the checks once, logging and recording the changes since the last run.
+func (p *PreflightRunner) run(ctx context.Context) {
+	log := log.FromContext(ctx).WithName("preflight")
+
+	results, err := utils.RunPreflight(ctx, p.Client)
+	if err == nil {
+		err = results.Err()
+	}
+	if err != nil {
+		log.Error(err, "LB host preflight checks failed", "results", results.String())
+	} else {
+		log.Info("LB host preflight checks passed", "results", results.String())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secret := p.credentialsSecret(ctx)
+	failed := make(map[string]string)
+	for _, result := range results {
+		key := fmt.Sprintf("%s/%s", result.Host.Address, result.Check)
+		if result.Err == nil {
+			continue
+		}
+		failed[key] = result.Err.Error()
+		if previous, seen := p.failed[key]; (!seen || previous != failed[key]) && secret != nil {
+			p.Recorder.Eventf(secret, corev1.EventTypeWarning, "PreflightFailed", "%s check on %s failed: %v", result.Check, result.Host, result.Err)
+		}
+	}
+	if secret != nil && len(results) > 0 && len(failed) == 0 && (!p.ran || len(p.failed) > 0) {
+		p.Recorder.Event(secret, corev1.EventTypeNormal, "PreflightPassed", fmt.Sprintf("LB host preflight checks passed: %s", results))
+	}
+
+	p.ran = true
+	p.err = err
+	p.failed = failed
+}
+
+// ReadyzCheck fails until the checks have run and while any of them fails.
+func (p *PreflightRunner) ReadyzCheck(_ *http.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.ran {
+		return fmt.Errorf("LB host preflight checks have not run yet")
+	}
+	return p.err
+}
+
+// credentialsSecret returns the credentials Secret to record events on, or nil if it cannot be read.
+func (p *PreflightRunner) credentialsSecret(ctx context.Context) *corev1.Secret {
+	key, err := utils.GetCredentialsSecretKey()
+	if err != nil {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := p.Get(ctx, key, secret); err != nil {
+		return nil
+	}
+	return secret
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+// LBHostReadiness is a readiness check that fails while an LB host cannot be reached over SSH or
+// does not run NGINX and Keepalived. Results are cached for TTL; a stale result is refreshed in
+// the background, so probes never wait on SSH and never hit the hosts more often than once per TTL.
+type LBHostReadiness struct {
+	client.Client
+	TTL time.Duration
+
+	probe utils.LBHostProbe
+
+	mu         sync.Mutex
+	checked    time.Time
+	err        error
+	refreshing bool
+}
+
+// Check returns the result of the last check of the LB hosts, starting a new check if it is
+// older than the TTL.
+func (r *LBHostReadiness) Check(_ *http.Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.TTL && !r.refreshing {
+		r.refreshing = true
+		go r.refresh()
+	}
+	if r.checked.IsZero() {
+		return fmt.Errorf("LB hosts have not been checked yet")
+	}
+	return r.err
+}
+
+// refresh checks every LB host and caches the result.
+func (r *LBHostReadiness) refresh() {
+	err := r.checkHosts()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checked = time.Now()
+	r.err = err
+	r.refreshing = false
+}
+
+// checkHosts checks every LB host, each bounded by the SSH dial and command timeouts.
+func (r *LBHostReadiness) checkHosts() error {
+	ctx := context.Background()
+	hosts, err := utils.GetLBHosts(ctx, r.Client)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, host := range hosts {
+		hostCtx, hostCancel := context.WithTimeout(ctx, utils.GetSSHDialTimeout()+utils.GetSSHCommandTimeout())
+		if err := r.probe.Check(hostCtx, r.Client, host); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+		hostCancel()
+	}
+	return errors.Join(errs...)
+}
@@ -2,9 +2,12 @@ package controllers
 
 import (
 	"context"
+	goerrors "errors"
+	"fmt"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors" // Corrected import
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
@@ -24,6 +27,8 @@ type ServiceReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Bootstrap must complete before the LB hosts can be configured
+	Bootstrap *Bootstrap
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -31,7 +36,7 @@ func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 	// Setting up the controller
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
+		For(&corev1.Service{}, builder.WithPredicates(ignoreVIPHolderUpdates())).
 		Watches(
 			&corev1.Endpoints{},
 			&handler.EnqueueRequestForObject{},
@@ -53,6 +58,28 @@ func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+// ignoreVIPHolderUpdates filters out Service updates that only change the VIP holder annotation,
+// so the VIP monitor does not trigger a reconcile
+func ignoreVIPHolderUpdates() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !equality.Semantic.DeepEqual(withoutVIPHolder(e.ObjectOld), withoutVIPHolder(e.ObjectNew))
+		},
+	}
+}
+
+// withoutVIPHolder returns a copy of the object without the VIP holder annotation and the
+// metadata that changes on every update.
+func withoutVIPHolder(obj client.Object) client.Object {
+	copied := obj.DeepCopyObject().(client.Object)
+	annotations := copied.GetAnnotations()
+	delete(annotations, utils.VIPHolderAnnotation)
+	copied.SetAnnotations(annotations)
+	copied.SetResourceVersion("")
+	copied.SetManagedFields(nil)
+	return copied
+}
+
 // Define the helper method for the ServiceReconciler struct
 func (r *ServiceReconciler) isLoadBalancerService(endpoints client.Object) bool {
 	ctx := context.Background()
@@ -82,6 +109,11 @@ func (r *ServiceReconciler) isLoadBalancerService(endpoints client.Object) bool
 func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
+	if result, pending := r.Bootstrap.Pending(); pending {
+		log.Info("Waiting for the LB host bootstrap", "request", req.NamespacedName)
+		return result, nil
+	}
+
 	// Fetch the Service instance
 	service := &corev1.Service{}
 	err := r.Get(ctx, req.NamespacedName, service)
@@ -100,7 +132,7 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	// Handle finalizer for cleanup
-	finalizerName := "sergiochamba.com/nginx-lb-operator-finalizer"
+	finalizerName := utils.ServiceFinalizer
 
 	if service.ObjectMeta.DeletionTimestamp.IsZero() {
 		// The object is not being deleted
@@ -115,6 +147,7 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		if utils.ContainsString(service.ObjectMeta.Finalizers, finalizerName) {
 			// Our finalizer is present, so let's handle any external dependency
 			if err := r.finalizeService(ctx, service); err != nil {
+				recordHostError(r.Recorder, service, err)
 				return ctrl.Result{}, err
 			}
 			// Remove finalizer and update
@@ -129,6 +162,7 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	// Main reconciliation logic
 	if err := r.reconcileService(ctx, service); err != nil {
 		log.Error(err, "Failed to reconcile service")
+		recordHostError(r.Recorder, service, err)
 		return ctrl.Result{}, err
 	}
 
@@ -167,10 +201,18 @@ func (r *ServiceReconciler) reconcileService(ctx context.Context, service *corev
 			r.Recorder.Event(service, corev1.EventTypeWarning, "GetIPError", "Failed to retrieve allocated IP")
 			return err
 		}
+
+		// Move the Service off a VIP another cluster sharing the LB hosts claimed in the meantime
+		ip, err = r.reallocateConflictingIP(ctx, service, ip)
+		if err != nil {
+			log.Error(err, "Failed to reallocate conflicting IP for service", "service", svcKey)
+			r.Recorder.Event(service, corev1.EventTypeWarning, "IPAllocationFailed", "Failed to reallocate conflicting IP")
+			return err
+		}
 	}
 
 	// Fetch the already allocated VRIDs (done at startup)
-	vrid1, vrid2, err := utils.GetOrAllocateVRIDs(ctx, r.Client)
+	vrids, err := utils.GetOrAllocateVRIDs(ctx, r.Client)
 	if err != nil {
 		log.Error(err, "Failed to retrieve VRIDs")
 		r.Recorder.Event(service, corev1.EventTypeWarning, "VRIDError", "Failed to retrieve VRIDs")
@@ -178,26 +220,44 @@ func (r *ServiceReconciler) reconcileService(ctx context.Context, service *corev
 	}
 
 	// Configure Keepalived
-	if err := utils.ConfigureKeepalived(ctx, r.Client, vrid1, vrid2); err != nil {
-		log.Error(err, "Failed to configure Keepalived", "service", svcKey)
-		r.Recorder.Event(service, corev1.EventTypeWarning, "KeepalivedError", "Failed to configure Keepalived")
-		return err
+	keepalivedStatuses, keepalivedErr := utils.ConfigureKeepalived(ctx, r.Client, vrids)
+	if keepalivedErr != nil {
+		log.Error(keepalivedErr, "Failed to configure Keepalived", "service", svcKey, "hosts", keepalivedStatuses.String())
+		r.Recorder.Event(service, corev1.EventTypeWarning, "KeepalivedError", hostStatusMessage("Failed to configure Keepalived", keepalivedStatuses))
+		// An unreachable LB host must not block the NGINX config of the others; the error is
+		// returned after NGINX is configured, so the reconcile is retried for the failed hosts
+		if !keepalivedStatuses.Partial() {
+			return keepalivedErr
+		}
+	} else {
+		log.Info("Updated Keepalived configuration", "hosts", keepalivedStatuses.String())
 	}
-	log.Info("Updated Keepalived configuration")
 
-	// Wait for 3 seconds for Keepalived to apply changes
-	log.Info("Waiting for Keepalived to apply VIPs", "duration", "3s")
-	r.Recorder.Event(service, corev1.EventTypeNormal, "Waiting", "Waiting for Keepalived to apply VIPs")
-	time.Sleep(3 * time.Second)
+	if keepalivedStatuses.Changed() {
+		// Wait for 3 seconds for Keepalived to apply changes
+		log.Info("Waiting for Keepalived to apply VIPs", "duration", "3s")
+		r.Recorder.Event(service, corev1.EventTypeNormal, "Waiting", "Waiting for Keepalived to apply VIPs")
+		if err := utils.SleepWithContext(ctx, 3*time.Second); err != nil {
+			return err
+		}
+	}
 
 	// Configure NGINX
-	if err := utils.ConfigureNGINX(ctx, r.Client, service, ip); err != nil {
-		log.Error(err, "Failed to configure NGINX for service", "service", svcKey)
-		r.Recorder.Event(service, corev1.EventTypeWarning, "NGINXConfigError", "Failed to configure NGINX")
+	nginxStatuses, err := utils.ConfigureNGINX(ctx, r.Client, service, ip)
+	if err != nil {
+		var vipConflictErr *utils.VIPConflictError
+		if goerrors.As(err, &vipConflictErr) {
+			// Another cluster claimed the VIP since it was checked; the retry allocates another one
+			if releaseErr := utils.ReleaseIP(ctx, r.Client, service); releaseErr != nil {
+				log.Error(releaseErr, "Failed to release conflicting IP", "service", svcKey, "ip", ip)
+			}
+		}
+		log.Error(err, "Failed to configure NGINX for service", "service", svcKey, "hosts", nginxStatuses.String())
+		r.Recorder.Event(service, corev1.EventTypeWarning, "NGINXConfigError", hostStatusMessage("Failed to configure NGINX", nginxStatuses))
 		return err
 	}
-	log.Info("Configured NGINX for service", "service", svcKey)
-	r.Recorder.Event(service, corev1.EventTypeNormal, "NGINXConfigured", "NGINX configured successfully")
+	log.Info("Configured NGINX for service", "service", svcKey, "hosts", nginxStatuses.String())
+	r.Recorder.Event(service, corev1.EventTypeNormal, "NGINXConfigured", hostStatusMessage("NGINX configured successfully", nginxStatuses))
 
 	// Refetch the latest version of the service before updating the status
 	if err := r.Get(ctx, svcKey, service); err != nil {
@@ -221,7 +281,31 @@ func (r *ServiceReconciler) reconcileService(ctx context.Context, service *corev
 	log.Info("Updated service status with LoadBalancer IP", "service", svcKey, "ip", ip)
 	r.Recorder.Event(service, corev1.EventTypeNormal, "StatusUpdated", "Service status updated with LoadBalancer IP")
 
-	return nil
+	return keepalivedErr
+}
+
+// reallocateConflictingIP returns the Service's IP, or if another cluster sharing the LB hosts
+// uses it, releases it and allocates a free one instead.
+func (r *ServiceReconciler) reallocateConflictingIP(ctx context.Context, service *corev1.Service, ip string) (string, error) {
+	otherClusterVIPs, err := utils.VIPsOfOtherClusters(ctx, r.Client)
+	if err != nil {
+		return "", err
+	}
+	owner, conflict := otherClusterVIPs[ip]
+	if !conflict {
+		return ip, nil
+	}
+
+	if err := utils.ReleaseIP(ctx, r.Client, service); err != nil {
+		return "", err
+	}
+	newIP, err := utils.AllocateIP(ctx, r.Client, service)
+	if err != nil {
+		return "", err
+	}
+	log.FromContext(ctx).Info("Reallocated IP used by another cluster", "service", client.ObjectKeyFromObject(service), "ip", ip, "cluster", owner, "newIP", newIP)
+	r.Recorder.Eventf(service, corev1.EventTypeWarning, "VIPReallocated", "VIP %s is used by cluster %s; allocated %s instead", ip, owner, newIP)
+	return newIP, nil
 }
 
 // finalizeService handles cleanup when a service is deleted
@@ -230,9 +314,10 @@ func (r *ServiceReconciler) finalizeService(ctx context.Context, service *corev1
 	svcKey := client.ObjectKeyFromObject(service)
 
 	// Remove NGINX configuration
-	if err := utils.RemoveNGINXConfig(ctx, r.Client, service); err != nil {
-		log.Error(err, "Failed to remove NGINX configuration for service", "service", svcKey)
-		r.Recorder.Event(service, corev1.EventTypeWarning, "NGINXRemovalFailed", "Failed to remove NGINX configuration")
+	removalStatuses, err := utils.RemoveNGINXConfig(ctx, r.Client, service)
+	if err != nil {
+		log.Error(err, "Failed to remove NGINX configuration for service", "service", svcKey, "hosts", removalStatuses.String())
+		r.Recorder.Event(service, corev1.EventTypeWarning, "NGINXRemovalFailed", hostStatusMessage("Failed to remove NGINX configuration", removalStatuses))
 		return err
 	}
 	log.Info("Removed NGINX configuration for service", "service", svcKey)
@@ -248,15 +333,16 @@ func (r *ServiceReconciler) finalizeService(ctx context.Context, service *corev1
 	r.Recorder.Event(service, corev1.EventTypeNormal, "IPReleased", "IP released successfully")
 
 	// Update Keepalived configuration
-	vrid1, vrid2, err := utils.GetOrAllocateVRIDs(ctx, r.Client) // Updated function call
+	vrids, err := utils.GetOrAllocateVRIDs(ctx, r.Client)
 	if err != nil {
 		log.Error(err, "Failed to get VRIDs during finalization")
 		r.Recorder.Event(service, corev1.EventTypeWarning, "VRIDError", "Failed to get VRIDs during finalization")
 		return err
 	}
-	if err := utils.ConfigureKeepalived(ctx, r.Client, vrid1, vrid2); err != nil {
-		log.Error(err, "Failed to update Keepalived during finalization", "service", svcKey)
-		r.Recorder.Event(service, corev1.EventTypeWarning, "KeepalivedUpdateError", "Failed to update Keepalived")
+	keepalivedStatuses, err := utils.ConfigureKeepalived(ctx, r.Client, vrids)
+	if err != nil {
+		log.Error(err, "Failed to update Keepalived during finalization", "service", svcKey, "hosts", keepalivedStatuses.String())
+		r.Recorder.Event(service, corev1.EventTypeWarning, "KeepalivedUpdateError", hostStatusMessage("Failed to update Keepalived", keepalivedStatuses))
 		return err
 	}
 	log.Info("Updated Keepalived configuration during finalization", "service", svcKey)
@@ -265,6 +351,37 @@ func (r *ServiceReconciler) finalizeService(ctx context.Context, service *corev1
 	return nil
 }
 
+// hostStatusMessage appends the per-LB-host apply status to an event message
+func hostStatusMessage(message string, statuses utils.HostApplyStatuses) string {
+	if len(statuses) == 0 {
+		return message
+	}
+	return fmt.Sprintf("%s [%s]", message, statuses)
+}
+
+// recordHostError raises a HostKeyMismatch event if err was caused by a changed NGINX server host key,
+// a VIPConflict event if another cluster sharing the LB hosts uses the Service's VIP,
+// or a ClusterIdentityConflict event if another cluster with the same name manages the LB host
+func recordHostError(recorder record.EventRecorder, obj runtime.Object, err error) bool {
+	var mismatchErr *utils.HostKeyMismatchError
+	if goerrors.As(err, &mismatchErr) {
+		recorder.Eventf(obj, corev1.EventTypeWarning, "HostKeyMismatch",
+			"Host key of %s changed: expected %s, got %s", mismatchErr.Host, mismatchErr.Expected, mismatchErr.Actual)
+		return true
+	}
+	var vipConflictErr *utils.VIPConflictError
+	if goerrors.As(err, &vipConflictErr) {
+		recorder.Event(obj, corev1.EventTypeWarning, "VIPConflict", vipConflictErr.Error())
+		return true
+	}
+	var conflictErr *utils.ClusterIdentityConflictError
+	if goerrors.As(err, &conflictErr) {
+		recorder.Event(obj, corev1.EventTypeWarning, "ClusterIdentityConflict", conflictErr.Error())
+		return true
+	}
+	return false
+}
+
 // handleDeletedService handles the scenario where the service was deleted before reconciliation
 func (r *ServiceReconciler) handleDeletedService(ctx context.Context, namespacedName client.ObjectKey) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
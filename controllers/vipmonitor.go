@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+var (
+	vipHolderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nginx_lb_operator_vip_holder",
+		Help: "Set to 1 for the LB host currently holding the VIP of a Service.",
+	}, []string{"vip", "service", "host"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(vipHolderGauge)
+}
+
+// VIPMonitor periodically polls the LB hosts for the VIPs they hold, annotates each Service with
+// the LB host holding its VIP, exports it as a metric, and records an event when a VIP moves.
+type VIPMonitor struct {
+	client.Client
+	Recorder record.EventRecorder
+	Interval time.Duration
+
+	// holders is the last seen holder of each VIP
+	holders map[string]string
+	// series are the label sets of the VIP holder metric set by the last poll
+	series map[vipHolderSeries]bool
+}
+
+// vipHolderSeries is the label set of a VIP holder metric.
+type vipHolderSeries struct {
+	vip, service, host string
+}
+
+// NeedLeaderElection runs the monitor on the leader only.
+func (m *VIPMonitor) NeedLeaderElection() bool {
+	return true
+}
+
+// Start polls the LB hosts until the context is cancelled.
+func (m *VIPMonitor) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("vip-monitor")
+	m.holders = make(map[string]string)
+	m.series = make(map[vipHolderSeries]bool)
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.poll(ctx); err != nil {
+			log.Error(err, "Failed to poll VIP holders")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll updates the holder annotation, metric and events of every allocated VIP.
+func (m *VIPMonitor) poll(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("vip-monitor")
+
+	allocatedIPs, err := utils.LoadAllocatedIPs(ctx, m.Client)
+	if err != nil {
+		return err
+	}
+	vips := make([]string, 0, len(allocatedIPs))
+	for vip := range allocatedIPs {
+		vips = append(vips, vip)
+	}
+	sort.Strings(vips)
+
+	holders, statuses, err := utils.GetVIPHolders(ctx, m.Client, vips)
+	if err != nil {
+		return err
+	}
+	unreachable := statuses.Err()
+	if unreachable != nil {
+		log.Error(unreachable, "Failed to query some LB hosts", "hosts", statuses.String())
+	}
+
+	// Hosts that could not be queried keep the VIPs they held at the last poll
+	series := make(map[vipHolderSeries]bool)
+	for _, status := range statuses {
+		if status.Err == nil {
+			continue
+		}
+		for previous := range m.series {
+			if previous.host == status.Host.Address && allocatedIPs[previous.vip] == previous.service {
+				series[previous] = true
+			}
+		}
+	}
+
+	for _, vip := range vips {
+		serviceKey := allocatedIPs[vip]
+		holder := "none"
+		if len(holders[vip]) > 0 {
+			addresses := make([]string, 0, len(holders[vip]))
+			for _, host := range holders[vip] {
+				addresses = append(addresses, host.Address)
+				series[vipHolderSeries{vip: vip, service: serviceKey, host: host.Address}] = true
+			}
+			holder = strings.Join(addresses, ",")
+		} else if unreachable != nil {
+			// The VIP may be held by a host that could not be queried
+			continue
+		}
+
+		previous, seen := m.holders[vip]
+		m.holders[vip] = holder
+		if seen && previous == holder {
+			continue
+		}
+
+		service, err := m.annotateService(ctx, serviceKey, holder)
+		if err != nil {
+			log.Error(err, "Failed to annotate Service with VIP holder", "service", serviceKey, "vip", vip)
+			continue
+		}
+		if !seen || service == nil {
+			continue
+		}
+
+		eventType := corev1.EventTypeNormal
+		if len(holders[vip]) != 1 {
+			// No holder means the VIP is down, several mean the LB hosts disagree on the MASTER
+			eventType = corev1.EventTypeWarning
+		}
+		m.Recorder.Event(service, eventType, "VIPMoved", fmt.Sprintf("VIP %s moved from %s to %s", vip, previous, holder))
+	}
+
+	// Only delete the series of VIPs that moved or were released, so a failed poll of one host
+	// does not drop the series of the others
+	for previous := range m.series {
+		if !series[previous] {
+			vipHolderGauge.DeleteLabelValues(previous.vip, previous.service, previous.host)
+		}
+	}
+	for current := range series {
+		vipHolderGauge.WithLabelValues(current.vip, current.service, current.host).Set(1)
+	}
+	m.series = series
+
+	// Forget VIPs that were released
+	for vip := range m.holders {
+		if _, allocated := allocatedIPs[vip]; !allocated {
+			delete(m.holders, vip)
+		}
+	}
+
+	return nil
+}
+
+// annotateService sets the VIP holder annotation on the Service identified by "namespace/name".
+// It returns nil if the Service no longer exists.
+func (m *VIPMonitor) annotateService(ctx context.Context, serviceKey, holder string) (*corev1.Service, error) {
+	namespace, name, found := strings.Cut(serviceKey, "/")
+	if !found {
+		return nil, fmt.Errorf("invalid Service reference '%s'", serviceKey)
+	}
+
+	service := &corev1.Service{}
+	if err := m.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, service); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if service.Annotations[utils.VIPHolderAnnotation] == holder {
+		return service, nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	if service.Annotations == nil {
+		service.Annotations = make(map[string]string)
+	}
+	service.Annotations[utils.VIPHolderAnnotation] = holder
+	if err := m.Patch(ctx, service, patch); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
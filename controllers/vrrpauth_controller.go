@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/sergiochamba/nginx-lb-operator/utils"
+)
+
+// VRRPAuthReconciler watches the VRRP auth Secret and the Keepalived settings, and pushes a
+// rotated auth pass or changed auth type to the LB hosts
+type VRRPAuthReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Bootstrap must complete before the LB hosts can be configured
+	Bootstrap *Bootstrap
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VRRPAuthReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	secretKey := types.NamespacedName{Name: utils.GetVRRPAuthSecretName(), Namespace: "nginx-lb-operator-system"}
+
+	// Only the VRRP auth Secret is of interest
+	isVRRPAuthSecret := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == secretKey.Name && obj.GetNamespace() == secretKey.Namespace
+	})
+	isKeepalivedConfig := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == "keepalived-config" && obj.GetNamespace() == secretKey.Namespace
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("vrrpauth").
+		For(&corev1.Secret{}, builder.WithPredicates(isVRRPAuthSecret)).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: secretKey}}
+			}),
+			builder.WithPredicates(isKeepalivedConfig),
+		).
+		Complete(r)
+}
+
+// Reconcile creates or rotates the VRRP auth pass and updates Keepalived on the LB hosts
+// when their configuration does not use it yet.
+func (r *VRRPAuthReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if result, pending := r.Bootstrap.Pending(); pending {
+		log.Info("Waiting for the LB host bootstrap", "request", req.NamespacedName)
+		return result, nil
+	}
+
+	auth, err := utils.GetOrCreateVRRPAuth(ctx, r.Client)
+	if err != nil {
+		log.Error(err, "Failed to get VRRP auth", "secret", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	applied, err := utils.VRRPAuthApplied(ctx, r.Client, auth)
+	if err != nil {
+		log.Error(err, "Failed to check VRRP auth on LB hosts")
+		// Retry later in case an LB host was only temporarily unreachable
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if applied {
+		return ctrl.Result{}, nil
+	}
+
+	// The Secret is absent when KEEPALIVED_AUTH_PASS overrides it; events are then only logged
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, req.NamespacedName, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		secret = nil
+	}
+
+	vrids, err := utils.GetOrAllocateVRIDs(ctx, r.Client)
+	if err != nil {
+		log.Error(err, "Failed to get VRIDs")
+		return ctrl.Result{}, err
+	}
+
+	statuses, err := utils.ConfigureKeepalived(ctx, r.Client, vrids)
+	if err != nil {
+		log.Error(err, "Failed to apply VRRP auth", "hosts", statuses.String())
+		if secret != nil && !recordHostError(r.Recorder, secret, err) {
+			r.Recorder.Event(secret, corev1.EventTypeWarning, "VRRPAuthUpdateFailed", hostStatusMessage("Failed to apply VRRP auth", statuses))
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	log.Info("Applied VRRP auth to LB hosts", "hosts", statuses.String())
+	if secret != nil {
+		r.Recorder.Event(secret, corev1.EventTypeNormal, "VRRPAuthUpdated", hostStatusMessage("Applied VRRP auth", statuses))
+	}
+	return ctrl.Result{}, nil
+}
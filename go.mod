@@ -4,6 +4,7 @@ go 1.23.2
 
 require (
 	github.com/pkg/sftp v1.13.7
+	github.com/prometheus/client_golang v1.19.1
 	golang.org/x/crypto v0.28.0
 	k8s.io/api v0.31.2
 	k8s.io/apimachinery v0.31.2
@@ -40,7 +41,6 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/prometheus/client_golang v1.19.1 // indirect
 	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/common v0.55.0 // indirect
 	github.com/prometheus/procfs v0.15.1 // indirect
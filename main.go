@@ -1,10 +1,9 @@
 package main
 
 import (
-	"context"
 	"flag"
+	"fmt"
 	"os"
-	"sync"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -14,6 +13,7 @@ import (
 	"github.com/sergiochamba/nginx-lb-operator/utils"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -41,6 +41,14 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	// Subcommands run once against the cluster instead of starting the manager
+	switch flag.Arg(0) {
+	case "decommission":
+		os.Exit(runDecommission())
+	case "preflight":
+		os.Exit(runPreflight())
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
@@ -49,18 +57,83 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "nginx-lb-operator",
+		// The process exits once the manager stops, so the next leader can take over right away
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// Reads that must bypass the cache, such as the kube-system namespace
+	utils.SetAPIReader(mgr.GetAPIReader())
+	utils.SetElected(mgr.Elected())
+
+	if !enableLeaderElection {
+		setupLog.Info("Leader election is disabled; run a single replica, as every replica would change the LB hosts")
+	}
+
+	// Allocate VRIDs and prepare the LB hosts on the leader, retrying while they are unreachable.
+	// Only the leader changes the LB hosts and the operator's state: the bootstrap, the Service and
+	// VRRP auth controllers and the VIP monitor run on the leader. Every replica reloads the
+	// credentials and runs the preflight and readiness checks, which only read from the hosts and
+	// record events; TOFU host keys are only pinned by the leader.
+	bootstrap := &controllers.Bootstrap{
+		Client:  mgr.GetClient(),
+		Elected: mgr.Elected(),
+	}
+	if err := mgr.Add(bootstrap); err != nil {
+		setupLog.Error(err, "unable to set up bootstrap")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.ServiceReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("nginx-lb-operator"),
+		Bootstrap: bootstrap,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.CredentialsReconciler{
 		Client:   mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("nginx-lb-operator"),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		setupLog.Error(err, "unable to create controller", "controller", "Credentials")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.VRRPAuthReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("nginx-lb-operator"),
+		Bootstrap: bootstrap,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VRRPAuth")
+		os.Exit(1)
+	}
+
+	// Report which LB host holds each VIP
+	if err := mgr.Add(&controllers.VIPMonitor{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("nginx-lb-operator"),
+		Interval: utils.GetVIPMonitorInterval(),
+	}); err != nil {
+		setupLog.Error(err, "unable to set up VIP monitor")
+		os.Exit(1)
+	}
+
+	// Check the LB hosts for missing prerequisites
+	preflight := &controllers.PreflightRunner{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("nginx-lb-operator"),
+		Interval: utils.GetPreflightInterval(),
+	}
+	if err := mgr.Add(preflight); err != nil {
+		setupLog.Error(err, "unable to set up preflight checks")
 		os.Exit(1)
 	}
 
@@ -69,41 +142,74 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	readiness := &controllers.LBHostReadiness{
+		Client: mgr.GetClient(),
+		TTL:    utils.GetReadinessCacheTTL(),
+	}
+	if err := mgr.AddReadyzCheck("lb-hosts", readiness.Check); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
-
-	// Wait group to prevent exit until everything finishes
-	var wg sync.WaitGroup
-	wg.Add(1) // Add 1 for the cache sync and VRID allocation
-
-	// Start the manager in a goroutine
-	go func() {
-		setupLog.Info("Starting manager...")
-		if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-			setupLog.Error(err, "problem running manager")
-			os.Exit(1)
-		}
-		wg.Done() // Mark as done when manager starts successfully
-	}()
-
-	// Wait for the cache to synchronize before starting other operations
-	setupLog.Info("Waiting for cache sync...")
-	if !mgr.GetCache().WaitForCacheSync(context.Background()) {
-		setupLog.Error(err, "Cache sync failed")
+	if err := mgr.AddReadyzCheck("preflight", preflight.ReadyzCheck); err != nil {
+		setupLog.Error(err, "unable to set up preflight ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("bootstrap", bootstrap.ReadyzCheck); err != nil {
+		setupLog.Error(err, "unable to set up bootstrap ready check")
 		os.Exit(1)
 	}
-	setupLog.Info("Cache sync successful")
 
-	// Allocate VRIDs after cache sync is complete
-	if err := utils.GetOrAllocateVRIDsOnStartup(context.Background(), mgr.GetClient()); err != nil {
-		setupLog.Error(err, "Failed to allocate VRIDs at operator startup")
+	setupLog.Info("Starting manager")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+}
+
+// runDecommission removes the cluster's footprint from the LB hosts and the operator state,
+// printing what was removed. The operator should be scaled down first.
+func runDecommission() int {
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
 
-	setupLog.Info("Manager cache synced and VRID allocation complete. Starting manager...")
+	removed, err := utils.DecommissionCluster(ctrl.SetupSignalHandler(), c)
+	for _, item := range removed {
+		fmt.Printf("removed %s\n", item)
+	}
+	if err != nil {
+		setupLog.Error(err, "Decommission incomplete")
+		return 1
+	}
+	fmt.Printf("cluster %s decommissioned\n", utils.GetClusterName())
+	return 0
+}
 
-	// Wait for goroutine to finish
-	wg.Wait()
+// runPreflight checks the LB hosts for the operator's prerequisites without changing them,
+// printing the result of every check.
+func runPreflight() int {
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+
+	results, err := utils.RunPreflight(ctrl.SetupSignalHandler(), c)
+	if err != nil {
+		setupLog.Error(err, "Preflight failed")
+		return 1
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("FAIL %s %s: %v\n", result.Host, result.Check, result.Err)
+		} else {
+			fmt.Printf("ok   %s %s: %s\n", result.Host, result.Check, result.Detail)
+		}
+	}
+	if results.Err() != nil {
+		return 1
+	}
+	return 0
 }
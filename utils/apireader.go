@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// apiReader reads directly from the API server, bypassing the manager's cache. It is set by the
+// manager; the subcommands use an uncached client anyway.
+var apiReader client.Reader
+
+// SetAPIReader sets the reader used for reads that must not go through the cache, either because
+// the operator may not list and watch the object or because a stale read would be harmful.
+func SetAPIReader(reader client.Reader) {
+	apiReader = reader
+}
+
+// uncachedReader returns the API reader, or c if none is set.
+func uncachedReader(c client.Client) client.Reader {
+	if apiReader != nil {
+		return apiReader
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	clusterIdentityMutex sync.Mutex
+	// verifiedIdentityHosts are the LB hosts on which the cluster identity is registered
+	verifiedIdentityHosts = make(map[string]bool)
+)
+
+// ClusterIdentityConflictError is returned when an LB host has the cluster name registered to
+// another Kubernetes cluster, which means both clusters would overwrite each other's files.
+type ClusterIdentityConflictError struct {
+	Host        LBHost
+	ClusterName string
+	Registered  string
+	Actual      string
+}
+
+func (e *ClusterIdentityConflictError) Error() string {
+	return fmt.Sprintf("cluster name %s is registered on LB host %s by cluster %s, not by this cluster %s; set a unique CLUSTER_NAME",
+		e.ClusterName, e.Host, e.Registered, e.Actual)
+}
+
+// GetClusterID returns the unique identity of the Kubernetes cluster, the UID of the kube-system namespace.
+// The namespace is read uncached, as the operator may only get namespaces, not list or watch them.
+func GetClusterID(ctx context.Context, c client.Client) (string, error) {
+	namespace := &corev1.Namespace{}
+	if err := uncachedReader(c).Get(ctx, client.ObjectKey{Name: "kube-system"}, namespace); err != nil {
+		return "", fmt.Errorf("failed to get cluster identity: %w", err)
+	}
+	return string(namespace.UID), nil
+}
+
+// VerifyClusterIdentity registers the cluster name and identity in the inventory file on every
+// LB host it is not yet registered on. It returns a ClusterIdentityConflictError if a host has the
+// name registered to another cluster; the operator must not manage files on such a host.
+func VerifyClusterIdentity(ctx context.Context, c client.Client) error {
+	clusterIdentityMutex.Lock()
+	defer clusterIdentityMutex.Unlock()
+
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	var clusterID string
+	for _, host := range hosts {
+		if verifiedIdentityHosts[host.Address] {
+			continue
+		}
+		if clusterID == "" {
+			if clusterID, err = GetClusterID(ctx, c); err != nil {
+				return err
+			}
+		}
+		if err := registerClusterIdentity(ctx, c, host, clusterID); err != nil {
+			return err
+		}
+		verifiedIdentityHosts[host.Address] = true
+	}
+	return nil
+}
+
+// registerClusterIdentity adds the cluster to the inventory file on the LB host, or checks that
+// the registered identity matches.
+func registerClusterIdentity(ctx context.Context, c client.Client, host LBHost, clusterID string) error {
+	clusterName := GetClusterName()
+
+	inventoryPath, err := clusterInventoryPath(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return withRemoteLock(ctx, c, host, inventoryPath+".lock", func() error {
+		content, err := FetchFileFromNGINXServer(ctx, c, host, inventoryPath)
+		if err != nil {
+			return fmt.Errorf("failed to fetch cluster inventory from %s: %w", host, err)
+		}
+
+		// Lines look like "<cluster name> <cluster identity>"
+		for _, line := range strings.Split(content, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 || fields[0] != clusterName {
+				continue
+			}
+			if fields[1] != clusterID {
+				return &ClusterIdentityConflictError{Host: host, ClusterName: clusterName, Registered: fields[1], Actual: clusterID}
+			}
+			return nil
+		}
+
+		content = strings.TrimRight(content, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		content += fmt.Sprintf("%s %s", clusterName, clusterID)
+		if err := CopyFileToNGINXServer(ctx, c, host, content, inventoryPath); err != nil {
+			return fmt.Errorf("failed to register cluster in inventory on %s: %w", host, err)
+		}
+		return nil
+	})
+}
+
+// unregisterClusterIdentity removes the cluster from the inventory file on the LB host.
+func unregisterClusterIdentity(ctx context.Context, c client.Client, host LBHost) error {
+	clusterName := GetClusterName()
+
+	inventoryPath, err := clusterInventoryPath(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return withRemoteLock(ctx, c, host, inventoryPath+".lock", func() error {
+		content, err := FetchFileFromNGINXServer(ctx, c, host, inventoryPath)
+		if err != nil {
+			return fmt.Errorf("failed to fetch cluster inventory from %s: %w", host, err)
+		}
+
+		lines := []string{}
+		for _, line := range strings.Split(content, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || fields[0] == clusterName {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if err := CopyFileToNGINXServer(ctx, c, host, strings.Join(lines, "\n"), inventoryPath); err != nil {
+			return fmt.Errorf("failed to update cluster inventory on %s: %w", host, err)
+		}
+
+		clusterIdentityMutex.Lock()
+		delete(verifiedIdentityHosts, host.Address)
+		clusterIdentityMutex.Unlock()
+		return nil
+	})
+}
+
+// clusterInventoryPath returns the path of the cluster inventory file on the LB hosts.
+func clusterInventoryPath(ctx context.Context, c client.Client) (string, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return profile.KeepalivedConfigPath("cluster_inventory"), nil
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	credentialsMutex sync.RWMutex
+	// activeCredentials is the credentials Secret currently used for SSH connections.
+	activeCredentials *corev1.Secret
+)
+
+// GetCredentialsSecretKey returns the namespaced name of the NGINX credentials Secret.
+func GetCredentialsSecretKey() (types.NamespacedName, error) {
+	secretName := os.Getenv("NGINX_CREDENTIALS_SECRET")
+	namespace := os.Getenv("NGINX_CREDENTIALS_NAMESPACE")
+
+	if secretName == "" || namespace == "" {
+		return types.NamespacedName{}, fmt.Errorf("NGINX_CREDENTIALS_SECRET and NGINX_CREDENTIALS_NAMESPACE must be set")
+	}
+	return types.NamespacedName{Name: secretName, Namespace: namespace}, nil
+}
+
+// getActiveCredentials returns the credentials in use, loading them from the Secret on first use.
+func getActiveCredentials(ctx context.Context, c client.Client) (*corev1.Secret, error) {
+	credentialsMutex.RLock()
+	secret := activeCredentials
+	credentialsMutex.RUnlock()
+	if secret != nil {
+		return secret, nil
+	}
+
+	secret, err := fetchCredentialsSecret(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	// Another caller may have loaded or rotated the credentials in the meantime
+	if activeCredentials == nil {
+		activeCredentials = secret
+	}
+	return activeCredentials, nil
+}
+
+// fetchCredentialsSecret reads the credentials Secret from the cluster.
+func fetchCredentialsSecret(ctx context.Context, c client.Client) (*corev1.Secret, error) {
+	key, err := GetCredentialsSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get SSH credentials secret: %w", err)
+	}
+	return secret, nil
+}
+
+// ReloadSSHCredentials re-reads the credentials Secret and switches to it once a test
+// connection with the new credentials succeeds. It returns true if the credentials changed.
+// On failure the previously active credentials are kept. Credentials loaded for the first time
+// replace nothing, so they are used without a test connection, as on first use.
+func ReloadSSHCredentials(ctx context.Context, c client.Client) (bool, error) {
+	secret, err := fetchCredentialsSecret(ctx, c)
+	if err != nil {
+		return false, err
+	}
+
+	credentialsMutex.Lock()
+	current := activeCredentials
+	if current == nil {
+		activeCredentials = secret
+	}
+	credentialsMutex.Unlock()
+
+	if current == nil {
+		return false, nil
+	}
+	if reflect.DeepEqual(current.Data, secret.Data) {
+		// Only metadata changed
+		return false, nil
+	}
+
+	if err := testSSHCredentials(ctx, c, secret); err != nil {
+		return false, fmt.Errorf("new SSH credentials failed validation, keeping previous credentials: %w", err)
+	}
+
+	credentialsMutex.Lock()
+	activeCredentials = secret
+	credentialsMutex.Unlock()
+
+	return true, nil
+}
+
+// testSSHCredentials opens a connection with the given credentials to every LB host they
+// describe and runs a no-op command.
+func testSSHCredentials(ctx context.Context, c client.Client, secret *corev1.Secret) error {
+	hosts, err := parseLBHosts(secret)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		if err := testSSHConnection(ctx, c, secret, host); err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// testSSHConnection opens a connection to a single LB host and runs a no-op command.
+func testSSHConnection(ctx context.Context, c client.Client, secret *corev1.Secret, host LBHost) error {
+	clientConfig, err := newSSHClientConfig(ctx, c, secret, host)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialSSH(ctx, clientConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	if err := runSSHCommand(ctx, session, "true"); err != nil {
+		return fmt.Errorf("failed to run test command: %w", err)
+	}
+	return nil
+}
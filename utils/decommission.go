@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ServiceFinalizer is added to LoadBalancer Services so their NGINX config is removed on deletion.
+const ServiceFinalizer = "sergiochamba.com/nginx-lb-operator-finalizer"
+
+// operatorStateConfigMaps are the ConfigMaps holding state the operator maintains for the cluster.
+var operatorStateConfigMaps = []string{"ip-allocations", "vrid-allocations", "vip-group-assignments"}
+
+// DecommissionCluster removes the cluster's footprint from the LB hosts and the operator state
+// from the Kubernetes cluster: the NGINX and Keepalived configs on every LB host, the include of
+// the latter in the main Keepalived config, the operator's ConfigMaps and its finalizer on
+// Services. NGINX is reloaded and Keepalived restarted on every host, and the cluster is removed
+// from the cluster inventory. Only once every host was cleaned up are the cluster's VRIDs in
+// VRID_allocations.conf and its VIPs in the VIP inventory released, and its ConfigMaps and
+// finalizers removed. It continues past failures and returns a description of everything removed
+// along with the combined error.
+func DecommissionCluster(ctx context.Context, c client.Client) ([]string, error) {
+	clusterName := GetClusterName()
+
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never remove the files of another cluster using the same name
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	var errs []error
+
+	for _, host := range hosts {
+		files, err := listClusterFiles(ctx, c, host, profile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		for _, file := range files {
+			if err := RemoveFileFromNGINXServer(ctx, c, host, file); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", host, err))
+				continue
+			}
+			removed = append(removed, fmt.Sprintf("%s on %s", file, host))
+		}
+
+		// The stream include in the main NGINX config is shared with other clusters and stays
+		if err := ExecuteSSHCommand(ctx, c, host, profile.Escalate(fmt.Sprintf("rmdir %s", profile.NginxClusterConfigDir()))); err == nil {
+			removed = append(removed, fmt.Sprintf("%s on %s", profile.NginxClusterConfigDir(), host))
+		}
+
+		if changed, err := removeKeepalivedInclude(ctx, c, host, profile); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		} else if changed {
+			removed = append(removed, fmt.Sprintf("include block from %s on %s", profile.KeepalivedMainConfig, host))
+		}
+
+		if err := ReloadNGINX(ctx, c, host); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+		if err := RestartKeepalived(ctx, c, host); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+
+		if err := unregisterClusterIdentity(ctx, c, host); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		} else {
+			removed = append(removed, fmt.Sprintf("cluster %s from the cluster inventory on %s", clusterName, host))
+		}
+	}
+
+	// The cluster's VRRP instances keep running with its VRIDs and VIPs on a host that was not
+	// cleaned up, so only hand them over to other clusters once every host was. The operator's
+	// state and the finalizers are kept as well, for the next run and for the Services whose
+	// configs are still on the host.
+	if len(errs) > 0 {
+		errs = append(errs, fmt.Errorf("kept the cluster's VRIDs, VIPs, ConfigMaps and Service finalizers as not every LB host was cleaned up; run decommission again"))
+		return removed, errors.Join(errs...)
+	}
+
+	released, err := releaseVRIDs(ctx, c, clusterName)
+	if err != nil {
+		errs = append(errs, err)
+	} else if released != "" {
+		removed = append(removed, fmt.Sprintf("VRIDs %s from VRID_allocations.conf", released))
+	}
+
+	if err := releaseClusterVIPListeners(ctx, c); err != nil {
+		errs = append(errs, err)
+	} else {
+		removed = append(removed, fmt.Sprintf("cluster %s from the VIP inventory", clusterName))
+	}
+
+	for _, name := range operatorStateConfigMaps {
+		configMap := &corev1.ConfigMap{}
+		configMap.Name = name
+		configMap.Namespace = "nginx-lb-operator-system"
+		if err := c.Delete(ctx, configMap); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				errs = append(errs, fmt.Errorf("failed to delete ConfigMap %s: %w", name, err))
+			}
+			continue
+		}
+		removed = append(removed, fmt.Sprintf("ConfigMap %s/%s", configMap.Namespace, name))
+	}
+
+	// Let Services be deleted without a running operator
+	services := &corev1.ServiceList{}
+	if err := c.List(ctx, services); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list Services: %w", err))
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if !ContainsString(service.Finalizers, ServiceFinalizer) {
+			continue
+		}
+		patch := client.MergeFrom(service.DeepCopy())
+		service.Finalizers = RemoveString(service.Finalizers, ServiceFinalizer)
+		if err := c.Patch(ctx, service, patch); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove finalizer from Service %s/%s: %w", service.Namespace, service.Name, err))
+			continue
+		}
+		removed = append(removed, fmt.Sprintf("finalizer of Service %s/%s", service.Namespace, service.Name))
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// releaseVRIDs removes the cluster's line from VRID_allocations.conf under the VRID lock. It returns
+// the released VRIDs, empty if the cluster had none.
+func releaseVRIDs(ctx context.Context, c client.Client, clusterName string) (string, error) {
+	vridAllocationMutex.Lock()
+	defer vridAllocationMutex.Unlock()
+
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	lockPath, err := vridLockPath(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	var released string
+	err = withRemoteLock(ctx, c, host, lockPath, func() error {
+		vridAllocationsData, err := FetchVRIDAllocationsFromNGINX(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to fetch VRID_allocations.conf from NGINX: %w", err)
+		}
+		vrids, exists := vridAllocationsData[clusterName]
+		if !exists {
+			return nil
+		}
+		delete(vridAllocationsData, clusterName)
+		if err := UpdateVRIDAllocationsFile(ctx, c, vridAllocationsData); err != nil {
+			return fmt.Errorf("failed to update VRID_allocations.conf: %w", err)
+		}
+		released = vrids
+		return nil
+	})
+	return released, err
+}
+
+// listClusterFiles returns the cluster's NGINX configs, in the cluster's own stream config
+// directory, and its Keepalived configs on the LB host, including the .secondary Keepalived config
+// and the conf.d NGINX configs written by earlier versions.
+func listClusterFiles(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) ([]string, error) {
+	pattern := profile.NginxConfigPath("*.conf")
+	keepalivedConfig := clusterKeepalivedConfigPath(profile)
+	output, err := ExecuteSSHCommandOutput(ctx, c, host, profile.Escalate(fmt.Sprintf("sh -c 'ls -1 %s %s %s.secondary 2>/dev/null || true'", pattern, keepalivedConfig, keepalivedConfig)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster configs: %w", err)
+	}
+
+	var files []string
+	for _, file := range strings.Split(output, "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+
+	legacyConfigs, err := listLegacyNGINXConfigs(ctx, c, host, profile)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, legacyConfigs...), nil
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "os"
+import (
+	"context"
+	"os"
+	"time"
+)
 
 // ContainsString checks if a string is present in a slice.
 func ContainsString(slice []string, s string) bool {
@@ -28,6 +32,11 @@ func GetClusterName() string {
 	return GetEnv("CLUSTER_NAME", "default-cluster")
 }
 
+// GetNetworkInterface returns the LB host interface carrying VRRP and, by default, the VIPs.
+func GetNetworkInterface() string {
+	return GetEnv("NGINX_NETWORK_INTERFACE", "eth0")
+}
+
 // GetEnv retrieves an environment variable or returns a default value.
 func GetEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -35,3 +44,58 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// GetEnvDuration retrieves a duration from an environment variable or returns a default value
+// if the variable is unset or invalid.
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// GetSSHDialTimeout returns how long to wait for an SSH connection and handshake.
+func GetSSHDialTimeout() time.Duration {
+	return GetEnvDuration("NGINX_SSH_DIAL_TIMEOUT", 10*time.Second)
+}
+
+// GetSSHCommandTimeout returns how long a single remote command may run.
+func GetSSHCommandTimeout() time.Duration {
+	return GetEnvDuration("NGINX_SSH_COMMAND_TIMEOUT", 60*time.Second)
+}
+
+// GetRemoteLockTTL returns after how long a lock on the LB host is considered abandoned. It
+// falls back to VRID_LOCK_TTL, its name in earlier versions.
+func GetRemoteLockTTL() time.Duration {
+	return GetEnvDuration("REMOTE_LOCK_TTL", GetEnvDuration("VRID_LOCK_TTL", 2*time.Minute))
+}
+
+// GetVIPMonitorInterval returns how often the LB hosts are polled for the VIPs they hold.
+func GetVIPMonitorInterval() time.Duration {
+	return GetEnvDuration("VIP_MONITOR_INTERVAL", 30*time.Second)
+}
+
+// GetPreflightInterval returns how often the LB host preflight checks are repeated.
+func GetPreflightInterval() time.Duration {
+	return GetEnvDuration("PREFLIGHT_INTERVAL", 5*time.Minute)
+}
+
+// GetReadinessCacheTTL returns how long the result of the LB host readiness check is reused.
+func GetReadinessCacheTTL() time.Duration {
+	return GetEnvDuration("READINESS_CACHE_TTL", 30*time.Second)
+}
+
+// SleepWithContext waits for the given duration, returning early if the context is cancelled.
+func SleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// HostKeyModeKnownHosts verifies host keys against NGINX_KNOWN_HOSTS (default).
+	HostKeyModeKnownHosts = "known-hosts"
+	// HostKeyModeTOFU pins the host key fingerprint on first contact and verifies it afterwards.
+	HostKeyModeTOFU = "tofu"
+)
+
+// HostKeyMismatchError is returned when an NGINX server presents a different host key
+// than the one that was trusted for it.
+type HostKeyMismatchError struct {
+	Host     string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected fingerprint %s, got %s", e.Host, e.Expected, e.Actual)
+}
+
+// GetHostKeysSecretName returns the name of the Secret holding pinned host key fingerprints.
+func GetHostKeysSecretName() string {
+	return GetEnv("NGINX_HOST_KEYS_SECRET", "nginx-host-keys")
+}
+
+// getHostKeyCallback builds the host key verification for the mode selected in the credentials Secret.
+func getHostKeyCallback(ctx context.Context, c client.Client, secret *corev1.Secret) (ssh.HostKeyCallback, error) {
+	mode := string(secret.Data["NGINX_HOST_KEY_MODE"])
+	switch mode {
+	case "", HostKeyModeKnownHosts:
+		knownHostsData := secret.Data["NGINX_KNOWN_HOSTS"]
+		if len(knownHostsData) == 0 {
+			return nil, fmt.Errorf("incomplete SSH credentials in secret: NGINX_KNOWN_HOSTS must be set")
+		}
+		return knownHostsCallback(knownHostsData)
+	case HostKeyModeTOFU:
+		return tofuHostKeyCallback(ctx, c, secret.Namespace), nil
+	default:
+		return nil, fmt.Errorf("unsupported NGINX_HOST_KEY_MODE %q", mode)
+	}
+}
+
+// knownHostsCallback verifies host keys against known_hosts data, reporting changed keys
+// as a HostKeyMismatchError.
+func knownHostsCallback(knownHostsData []byte) (ssh.HostKeyCallback, error) {
+	// knownhosts only reads from files, so write the data to a temp file
+	knownHostsFile, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file for known_hosts: %w", err)
+	}
+	defer os.Remove(knownHostsFile.Name())
+
+	if _, err := knownHostsFile.Write(knownHostsData); err != nil {
+		knownHostsFile.Close()
+		return nil, fmt.Errorf("failed to write known_hosts data: %w", err)
+	}
+	knownHostsFile.Close()
+
+	callback, err := knownhosts.New(knownHostsFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host key callback: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+			// The host is known but presented a different key
+			return &HostKeyMismatchError{
+				Host:     hostname,
+				Expected: ssh.FingerprintSHA256(keyErr.Want[0].Key),
+				Actual:   ssh.FingerprintSHA256(key),
+			}
+		}
+		return err
+	}, nil
+}
+
+// tofuHostKeyCallback records the host key fingerprint of each NGINX server in the host keys
+// Secret on first contact and strictly verifies it on every later connection. The Secret is read
+// uncached, and a write that races with another connection pinning a key is retried after
+// re-reading the Secret, so a key pinned concurrently is verified rather than overwritten. Only
+// the leader pins keys; standby replicas fail connections to hosts that are not pinned yet.
+func tofuHostKeyCallback(ctx context.Context, c client.Client, namespace string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		actual := ssh.FingerprintSHA256(key)
+		dataKey := hostKeyDataKey(hostname)
+		secretKey := client.ObjectKey{Name: GetHostKeysSecretName(), Namespace: namespace}
+
+		racedWrite := func(err error) bool {
+			return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+		}
+		return retry.OnError(retry.DefaultRetry, racedWrite, func() error {
+			secret := &corev1.Secret{}
+			err := uncachedReader(c).Get(ctx, secretKey, secret)
+			if apierrors.IsNotFound(err) {
+				if !isLeader() {
+					return errHostKeyNotPinned(hostname)
+				}
+				// First contact with any host
+				secret = &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      secretKey.Name,
+						Namespace: secretKey.Namespace,
+					},
+					Data: map[string][]byte{
+						dataKey: []byte(actual),
+					},
+				}
+				if err := c.Create(ctx, secret); err != nil {
+					return fmt.Errorf("failed to pin host key for %s: %w", hostname, err)
+				}
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get pinned host keys: %w", err)
+			}
+
+			if expected, exists := secret.Data[dataKey]; exists {
+				if string(expected) != actual {
+					return &HostKeyMismatchError{
+						Host:     hostname,
+						Expected: string(expected),
+						Actual:   actual,
+					}
+				}
+				return nil
+			}
+
+			// First contact with this host
+			if !isLeader() {
+				return errHostKeyNotPinned(hostname)
+			}
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data[dataKey] = []byte(actual)
+			if err := c.Update(ctx, secret); err != nil {
+				return fmt.Errorf("failed to pin host key for %s: %w", hostname, err)
+			}
+			return nil
+		})
+	}
+}
+
+// errHostKeyNotPinned is returned to standby replicas connecting to a host whose key the leader
+// has not pinned yet.
+func errHostKeyNotPinned(hostname string) error {
+	return fmt.Errorf("host key of %s is not pinned yet; only the leader pins host keys", hostname)
+}
+
+// hostKeyDataKey converts a "host:port" address into a valid Secret data key.
+func hostKeyDataKey(hostname string) string {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	return strings.ReplaceAll(host, ":", "_")
+}
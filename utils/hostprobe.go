@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LBHostProbe checks that NGINX and Keepalived are running on the LB hosts. It keeps one SSH
+// connection per host open between checks, so probing does not cost a handshake every time.
+type LBHostProbe struct {
+	mu    sync.Mutex
+	conns map[string]*ssh.Client
+}
+
+// Check verifies that the cached SSH connection to the LB host still works, redialing it if
+// not, and that the nginx and keepalived processes are running on the host.
+func (p *LBHostProbe) Check(ctx context.Context, c client.Client, host LBHost) error {
+	conn, err := p.connection(ctx, c, host)
+	if err != nil {
+		return err
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		p.drop(host, conn)
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	// Prints the processes that are not running
+	var output bytes.Buffer
+	session.Stdout = &output
+	if err := runSSHCommand(ctx, session, `sh -c 'for p in nginx keepalived; do pgrep -x $p >/dev/null || echo $p; done'`); err != nil {
+		p.drop(host, conn)
+		return fmt.Errorf("failed to check processes: %w", err)
+	}
+	if missing := strings.Fields(output.String()); len(missing) > 0 {
+		return fmt.Errorf("not running: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Close closes the cached SSH connections.
+func (p *LBHostProbe) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for address, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, address)
+	}
+}
+
+// connection returns the cached SSH connection to the LB host if it still answers a keepalive
+// request, or dials a new one.
+func (p *LBHostProbe) connection(ctx context.Context, c client.Client, host LBHost) (*ssh.Client, error) {
+	p.mu.Lock()
+	conn := p.conns[host.Address]
+	p.mu.Unlock()
+
+	if conn != nil {
+		if err := sendKeepalive(ctx, conn); err == nil {
+			return conn, nil
+		}
+		p.drop(host, conn)
+	}
+
+	conn, err := DialNGINXServer(ctx, c, host)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if p.conns == nil {
+		p.conns = make(map[string]*ssh.Client)
+	}
+	if previous := p.conns[host.Address]; previous != nil {
+		previous.Close()
+	}
+	p.conns[host.Address] = conn
+	p.mu.Unlock()
+	return conn, nil
+}
+
+// drop closes the SSH connection to the LB host and forgets it if it is still the cached one.
+func (p *LBHostProbe) drop(host LBHost, conn *ssh.Client) {
+	conn.Close()
+	p.mu.Lock()
+	if p.conns[host.Address] == conn {
+		delete(p.conns, host.Address)
+	}
+	p.mu.Unlock()
+}
+
+// sendKeepalive checks that the SSH connection is alive. A connection that does not answer
+// before the context is done is closed.
+func sendKeepalive(ctx context.Context, conn *ssh.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HostProfile describes how the operator manages files and services on the NGINX server.
+type HostProfile struct {
+	// Escalation is the privilege escalation wrapper, e.g. "sudo" or "doas". Empty runs commands as the SSH user.
+	Escalation string
+	// NginxConfigDir holds a directory of stream configs per cluster
+	NginxConfigDir string
+	// NginxMainConfig is the config NGINX loads, which must include the stream configs
+	NginxMainConfig     string
+	KeepalivedConfigDir string
+	// KeepalivedMainConfig is the config Keepalived loads, which must include the cluster's config
+	KeepalivedMainConfig string
+
+	NginxValidateCommand      string
+	NginxReloadCommand        string
+	KeepalivedValidateCommand string
+	KeepalivedReloadCommand   string
+	KeepalivedRestartCommand  string
+}
+
+// LoadHostProfile loads the host profile from the ConfigMap, falling back to defaults
+// for a stock systemd host using sudo when the ConfigMap or a key is missing.
+func LoadHostProfile(ctx context.Context, c client.Client) (*HostProfile, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Name: "lb-host-profile", Namespace: "nginx-lb-operator-system"}, configMap)
+	if err != nil && client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("failed to load host profile: %w", err)
+	}
+	data := configMap.Data
+
+	profile := &HostProfile{
+		Escalation:          "sudo",
+		NginxConfigDir:      "/etc/nginx/stream.d",
+		NginxMainConfig:     "/etc/nginx/nginx.conf",
+		KeepalivedConfigDir: "/etc/keepalived",
+	}
+	// An empty escalation value is meaningful, so only the key's absence selects the default
+	if escalation, exists := data["escalation"]; exists {
+		profile.Escalation = strings.TrimSpace(escalation)
+	}
+	if dir := strings.TrimSpace(data["nginx_config_dir"]); dir != "" {
+		profile.NginxConfigDir = dir
+	}
+	if mainConfig := strings.TrimSpace(data["nginx_main_config"]); mainConfig != "" {
+		profile.NginxMainConfig = mainConfig
+	}
+	if dir := strings.TrimSpace(data["keepalived_config_dir"]); dir != "" {
+		profile.KeepalivedConfigDir = dir
+	}
+	profile.KeepalivedMainConfig = profile.KeepalivedConfigPath("keepalived.conf")
+	if mainConfig := strings.TrimSpace(data["keepalived_main_config"]); mainConfig != "" {
+		profile.KeepalivedMainConfig = mainConfig
+	}
+
+	profile.NginxValidateCommand = profileCommand(data, "nginx_validate_command", profile.Escalate("nginx -t"))
+	profile.NginxReloadCommand = profileCommand(data, "nginx_reload_command", profile.Escalate("nginx -s reload"))
+	profile.KeepalivedValidateCommand = profileCommand(data, "keepalived_validate_command", "")
+	profile.KeepalivedReloadCommand = profileCommand(data, "keepalived_reload_command", profile.Escalate("systemctl reload-or-restart keepalived"))
+	profile.KeepalivedRestartCommand = profileCommand(data, "keepalived_restart_command", profile.Escalate("systemctl restart keepalived"))
+
+	return profile, nil
+}
+
+// profileCommand returns the command configured under key, or the default if it is not set.
+func profileCommand(data map[string]string, key, defaultCommand string) string {
+	if command := strings.TrimSpace(data[key]); command != "" {
+		return command
+	}
+	return defaultCommand
+}
+
+// Escalate prefixes the command with the privilege escalation wrapper.
+func (p *HostProfile) Escalate(command string) string {
+	if p.Escalation == "" {
+		return command
+	}
+	return fmt.Sprintf("%s %s", p.Escalation, command)
+}
+
+// NginxClusterConfigDir returns the cluster's directory in the NGINX config directory.
+func (p *HostProfile) NginxClusterConfigDir() string {
+	return path.Join(p.NginxConfigDir, GetClusterName())
+}
+
+// NginxConfigPath returns the path of a file in the cluster's NGINX config directory.
+func (p *HostProfile) NginxConfigPath(filename string) string {
+	return path.Join(p.NginxClusterConfigDir(), filename)
+}
+
+// KeepalivedConfigPath returns the path of a file in the Keepalived config directory.
+func (p *HostProfile) KeepalivedConfigPath(filename string) string {
+	return path.Join(p.KeepalivedConfigDir, filename)
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// LBHostRolePrimary is the first LB host in VRRP node order, Keepalived MASTER for the
+	// first VIP group, and holds the shared VRID allocations file.
+	LBHostRolePrimary = "primary"
+	// LBHostRoleSecondary is any other LB host; secondaries follow the primary in the order listed.
+	LBHostRoleSecondary = "secondary"
+)
+
+// LBHost is an NGINX/Keepalived load balancer node.
+type LBHost struct {
+	Address string
+	Role    string
+}
+
+func (h LBHost) String() string {
+	return fmt.Sprintf("%s (%s)", h.Address, h.Role)
+}
+
+// GetLBHosts returns the LB hosts described by the active credentials.
+func GetLBHosts(ctx context.Context, c client.Client) ([]LBHost, error) {
+	secret, err := getActiveCredentials(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return parseLBHosts(secret)
+}
+
+// GetPrimaryLBHost returns the primary LB host.
+func GetPrimaryLBHost(ctx context.Context, c client.Client) (LBHost, error) {
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return LBHost{}, err
+	}
+	for _, host := range hosts {
+		if host.Role == LBHostRolePrimary {
+			return host, nil
+		}
+	}
+	return LBHost{}, fmt.Errorf("no primary LB host configured")
+}
+
+// parseLBHosts parses the LB hosts from the credentials Secret. NGINX_SERVERS lists one
+// host per line as "<address> <role>"; a single NGINX_SERVER_IP is treated as the primary.
+func parseLBHosts(secret *corev1.Secret) ([]LBHost, error) {
+	serversData := string(secret.Data["NGINX_SERVERS"])
+	if strings.TrimSpace(serversData) == "" {
+		serverIP := strings.TrimSpace(string(secret.Data["NGINX_SERVER_IP"]))
+		if serverIP == "" {
+			return nil, fmt.Errorf("incomplete SSH credentials in secret: NGINX_SERVERS or NGINX_SERVER_IP must be set")
+		}
+		return []LBHost{{Address: serverIP, Role: LBHostRolePrimary}}, nil
+	}
+
+	hosts := []LBHost{}
+	seen := make(map[string]bool)
+	primaries := 0
+	for _, line := range strings.Split(serversData, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid NGINX_SERVERS entry '%s': expected '<address> <role>'", line)
+		}
+
+		host := LBHost{Address: fields[0], Role: strings.ToLower(fields[1])}
+		if host.Role != LBHostRolePrimary && host.Role != LBHostRoleSecondary {
+			return nil, fmt.Errorf("invalid role '%s' for LB host %s", fields[1], host.Address)
+		}
+		if seen[host.Address] {
+			return nil, fmt.Errorf("duplicate LB host %s in NGINX_SERVERS", host.Address)
+		}
+		seen[host.Address] = true
+		if host.Role == LBHostRolePrimary {
+			primaries++
+		}
+		hosts = append(hosts, host)
+	}
+
+	if primaries != 1 {
+		return nil, fmt.Errorf("NGINX_SERVERS must contain exactly one primary LB host, found %d", primaries)
+	}
+	return hosts, nil
+}
+
+// HostApplyStatus is the result of applying configuration to one LB host.
+type HostApplyStatus struct {
+	Host LBHost
+	Err  error
+	// Changed is set if the host's configuration changed and was reloaded
+	Changed bool
+}
+
+// HostApplyStatuses collects the per-host results of applying configuration.
+type HostApplyStatuses []HostApplyStatus
+
+// Err returns the combined error of all hosts that failed, or nil if all succeeded.
+func (s HostApplyStatuses) Err() error {
+	var errs []error
+	for _, status := range s {
+		if status.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", status.Host, status.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Partial reports whether some hosts succeeded while others failed.
+func (s HostApplyStatuses) Partial() bool {
+	failed := 0
+	for _, status := range s {
+		if status.Err != nil {
+			failed++
+		}
+	}
+	return failed > 0 && failed < len(s)
+}
+
+// Changed reports whether the configuration of any host changed.
+func (s HostApplyStatuses) Changed() bool {
+	for _, status := range s {
+		if status.Changed {
+			return true
+		}
+	}
+	return false
+}
+
+// String summarizes the per-host results, e.g. "10.1.1.52 (primary): ok, 10.1.1.53 (secondary): failed".
+func (s HostApplyStatuses) String() string {
+	parts := make([]string, 0, len(s))
+	for _, status := range s {
+		result := "ok"
+		if status.Err != nil {
+			result = "failed"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", status.Host, result))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -32,10 +34,19 @@ func AllocateIP(ctx context.Context, c client.Client, service *corev1.Service) (
 		return "", err
 	}
 
+	// Skip VIPs other clusters sharing the LB hosts already use
+	otherClusterVIPs, err := VIPsOfOtherClusters(ctx, c)
+	if err != nil {
+		return "", err
+	}
+
 	svcIdentifier := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
 
 	// Allocate an IP
 	for _, ip := range ipPool {
+		if _, owned := otherClusterVIPs[ip]; owned {
+			continue
+		}
 		if _, allocated := allocatedIPs[ip]; !allocated {
 			// Mark IP as allocated
 			allocatedIPs[ip] = svcIdentifier
@@ -49,47 +60,171 @@ func AllocateIP(ctx context.Context, c client.Client, service *corev1.Service) (
 	return "", fmt.Errorf("no available IPs in the pool")
 }
 
+// VIPAttributes describe how a VIP is configured on the LB hosts. Unset attributes are left
+// to Keepalived's defaults: a /32 (or /128) on the VRRP instance interface.
+type VIPAttributes struct {
+	Interface string
+	PrefixLen int
+	Label     string
+	VLAN      int
+}
+
+// Device returns the interface the VIP is added to, the VLAN subinterface if a VLAN is set.
+func (a VIPAttributes) Device() string {
+	device := a.Interface
+	if a.VLAN > 0 {
+		if device == "" {
+			device = GetNetworkInterface()
+		}
+		device = fmt.Sprintf("%s.%d", device, a.VLAN)
+	}
+	return device
+}
+
+// Format renders the VIP as a Keepalived virtual_ipaddress entry, e.g.
+// "10.1.1.200/24 dev ens192 label ens192:vip".
+func (a VIPAttributes) Format(ip string) string {
+	entry := ip
+	if a.PrefixLen > 0 {
+		entry = fmt.Sprintf("%s/%d", entry, a.PrefixLen)
+	}
+	if device := a.Device(); device != "" {
+		entry = fmt.Sprintf("%s dev %s", entry, device)
+	}
+	if a.Label != "" {
+		entry = fmt.Sprintf("%s label %s", entry, a.Label)
+	}
+	return entry
+}
+
 // LoadIPPool loads the IP pool from the ConfigMap.
 func LoadIPPool(ctx context.Context, c client.Client) ([]string, error) {
+	ipPool, _, err := loadIPPoolConfig(ctx, c)
+	return ipPool, err
+}
+
+// LoadVIPAttributes loads the attributes of every IP in the pool from the ConfigMap.
+func LoadVIPAttributes(ctx context.Context, c client.Client) (map[string]VIPAttributes, error) {
+	_, attributes, err := loadIPPoolConfig(ctx, c)
+	return attributes, err
+}
+
+// loadIPPoolConfig loads and parses the ip_pool key of the IP pool ConfigMap.
+func loadIPPoolConfig(ctx context.Context, c client.Client) ([]string, map[string]VIPAttributes, error) {
 	configMap := &corev1.ConfigMap{}
 	err := c.Get(ctx, client.ObjectKey{Name: "ip-pool-config", Namespace: "nginx-lb-operator-system"}, configMap)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load IP pool config: %w", err)
+		return nil, nil, fmt.Errorf("failed to load IP pool config: %w", err)
 	}
 
 	ipPoolData, ok := configMap.Data["ip_pool"]
 	if !ok {
-		return nil, fmt.Errorf("ip_pool not found in ConfigMap")
+		return nil, nil, fmt.Errorf("ip_pool not found in ConfigMap")
 	}
+	return parseIPPool(ipPoolData)
+}
 
-	// Parse IPs and ranges
+// parseIPPool parses one IP or IP range per line, each optionally followed by attributes:
+// "10.1.1.200 - 10.1.1.219 dev=ens192 prefix=24 label=ens192:vip vlan=20".
+func parseIPPool(ipPoolData string) ([]string, map[string]VIPAttributes, error) {
 	ipPool := []string{}
+	attributes := make(map[string]VIPAttributes)
 	lines := strings.Split(ipPoolData, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if strings.Contains(line, "-") {
+
+		// Attributes are the key=value fields, the rest is the IP or range
+		var addressFields, attributeFields []string
+		for _, field := range strings.Fields(line) {
+			if strings.Contains(field, "=") {
+				attributeFields = append(attributeFields, field)
+			} else {
+				addressFields = append(addressFields, field)
+			}
+		}
+		address := strings.Join(addressFields, " ")
+
+		var ips []string
+		if strings.Contains(address, "-") {
 			// IP range
-			ips, err := parseIPRange(line)
+			rangeIPs, err := parseIPRange(address)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse IP range '%s': %w", line, err)
+				return nil, nil, fmt.Errorf("failed to parse IP range '%s': %w", address, err)
 			}
-			ipPool = append(ipPool, ips...)
+			ips = rangeIPs
 		} else {
 			// Single IP
-			ip := net.ParseIP(line)
+			ip := net.ParseIP(address)
 			if ip == nil {
-				return nil, fmt.Errorf("invalid IP address '%s'", line)
+				return nil, nil, fmt.Errorf("invalid IP address '%s'", address)
 			}
-			ipPool = append(ipPool, ip.String())
+			ips = []string{ip.String()}
+		}
+
+		lineAttributes, err := parseVIPAttributes(attributeFields, net.ParseIP(ips[0]).To4() == nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid attributes for '%s': %w", address, err)
+		}
+		for _, ip := range ips {
+			attributes[ip] = lineAttributes
+		}
+		ipPool = append(ipPool, ips...)
+	}
+	return ipPool, attributes, nil
+}
+
+// parseVIPAttributes parses the key=value attributes of an ip_pool line.
+func parseVIPAttributes(fields []string, isIPv6 bool) (VIPAttributes, error) {
+	attributes := VIPAttributes{}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		if value == "" {
+			return attributes, fmt.Errorf("missing value for %s", key)
+		}
+		switch key {
+		case "dev":
+			attributes.Interface = value
+		case "label":
+			attributes.Label = value
+		case "prefix":
+			maxPrefixLen := 32
+			if isIPv6 {
+				maxPrefixLen = 128
+			}
+			prefixLen, err := strconv.Atoi(value)
+			if err != nil || prefixLen < 1 || prefixLen > maxPrefixLen {
+				return attributes, fmt.Errorf("prefix must be between 1 and %d", maxPrefixLen)
+			}
+			attributes.PrefixLen = prefixLen
+		case "vlan":
+			vlan, err := strconv.Atoi(value)
+			if err != nil || vlan < 1 || vlan > 4094 {
+				return attributes, fmt.Errorf("vlan must be between 1 and 4094")
+			}
+			attributes.VLAN = vlan
+		default:
+			return attributes, fmt.Errorf("unknown attribute '%s'", key)
+		}
+	}
+
+	// The kernel requires address labels to start with the device name
+	if attributes.Label != "" {
+		device := attributes.Device()
+		if device == "" {
+			device = GetNetworkInterface()
+		}
+		if !strings.HasPrefix(attributes.Label, device+":") {
+			return attributes, fmt.Errorf("label must start with '%s:'", device)
 		}
 	}
-	return ipPool, nil
+	return attributes, nil
 }
 
-// parseIPRange parses a range like "10.1.1.60 - 10.1.1.65".
+// parseIPRange parses a range like "10.1.1.60 - 10.1.1.65". Both ends must be of the same
+// address family, and the start must not be after the end.
 func parseIPRange(rangeStr string) ([]string, error) {
 	parts := strings.Split(rangeStr, "-")
 	if len(parts) != 2 {
@@ -100,38 +235,37 @@ func parseIPRange(rangeStr string) ([]string, error) {
 	if startIP == nil || endIP == nil {
 		return nil, fmt.Errorf("invalid IP in range")
 	}
-	ips := []string{}
-	for ip := startIP; !ipAfter(ip, endIP); ip = nextIP(ip) {
-		ips = append(ips, ip.String())
+	if (startIP.To4() == nil) != (endIP.To4() == nil) {
+		return nil, fmt.Errorf("IP range mixes IPv4 and IPv6")
+	}
+	if startIP.To4() != nil {
+		startIP, endIP = startIP.To4(), endIP.To4()
+	}
+	if bytes.Compare(startIP, endIP) > 0 {
+		return nil, fmt.Errorf("IP range is empty: %s is after %s", startIP, endIP)
 	}
-	return ips, nil
-}
-
-// ipAfter checks if ip1 is after ip2.
-func ipAfter(ip1, ip2 net.IP) bool {
-	return bytesCompare(ip1.To4(), ip2.To4()) > 0
-}
 
-// bytesCompare compares two byte slices.
-func bytesCompare(a, b []byte) int {
-	for i := 0; i < len(a) && i < len(b); i++ {
-		if a[i] != b[i] {
-			return int(a[i]) - int(b[i])
+	ips := []string{}
+	for ip := startIP; ; ip = nextIP(ip) {
+		ips = append(ips, ip.String())
+		if ip.Equal(endIP) {
+			break
 		}
 	}
-	return len(a) - len(b)
+	return ips, nil
 }
 
-// nextIP calculates the next IP address.
+// nextIP returns the address following ip.
 func nextIP(ip net.IP) net.IP {
-	ip = ip.To4()
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for j := len(next) - 1; j >= 0; j-- {
+		next[j]++
+		if next[j] > 0 {
 			break
 		}
 	}
-	return ip
+	return next
 }
 
 // LoadAllocatedIPs loads allocated IPs from the ConfigMap.
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "IPv4 range",
+			input: "10.1.1.60 - 10.1.1.62",
+			want:  []string{"10.1.1.60", "10.1.1.61", "10.1.1.62"},
+		},
+		{
+			name:  "single address range",
+			input: "10.1.1.60-10.1.1.60",
+			want:  []string{"10.1.1.60"},
+		},
+		{
+			name:  "octet carry",
+			input: "10.1.1.255 - 10.1.2.1",
+			want:  []string{"10.1.1.255", "10.1.2.0", "10.1.2.1"},
+		},
+		{
+			name:  "IPv6 range",
+			input: "fd00::fffe - fd00::1:0",
+			want:  []string{"fd00::fffe", "fd00::ffff", "fd00::1:0"},
+		},
+		{
+			name:  "end of address space",
+			input: "255.255.255.254 - 255.255.255.255",
+			want:  []string{"255.255.255.254", "255.255.255.255"},
+		},
+		{
+			name:    "reversed range",
+			input:   "10.1.1.65 - 10.1.1.60",
+			wantErr: true,
+		},
+		{
+			name:    "mixed address families",
+			input:   "10.1.1.60 - fd00::1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			input:   "10.1.1.60 - 10.1.1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIPRange(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIPRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIPRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPPoolReversedRange(t *testing.T) {
+	if _, _, err := parseIPPool("10.1.1.65 - 10.1.1.60 dev=ens192\n"); err == nil {
+		t.Error("parseIPPool() accepted a reversed IP range")
+	}
+}
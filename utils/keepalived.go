@@ -5,134 +5,349 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
-	"os"
-	"sort"
+	"net"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// Embed the templates
+// Embed the template
 //
-//go:embed templates/keepalived_primary.conf.tmpl
-var keepalivedPrimaryTemplate string
+//go:embed templates/keepalived.conf.tmpl
+var keepalivedTemplate string
 
-//go:embed templates/keepalived_secondary.conf.tmpl
-var keepalivedSecondaryTemplate string
+var (
+	keepalivedConfigMutex sync.Mutex
+)
+
+// VRRPInstance is a vrrp_instance rendered into the Keepalived configuration of one LB node.
+type VRRPInstance struct {
+	Name            string
+	State           string
+	VirtualRouterID int
+	Priority        int
+	AdvertInt       int
+	NoPreempt       bool
+	PreemptDelay    int
+	VIPs            []string
+	// ExcludedVIPs are managed with the instance but left out of the VRRP adverts.
+	ExcludedVIPs []string
+}
+
+// KeepalivedConfigData is the template data of the Keepalived configuration of one LB node.
+type KeepalivedConfigData struct {
+	ClusterName string
+	Node        LBHost
+	Interface   string
+	AuthType    string
+	AuthPass    string
+	HealthCheck *HealthCheck
+	// Unicast sends VRRP adverts from the node address to UnicastPeers instead of multicast.
+	Unicast      bool
+	UnicastPeers []string
+	Instances    []VRRPInstance
+}
 
 // ConfigureKeepalived generates and updates Keepalived configurations.
-// It distributes the allocated IPs into two VIP groups equally.
-func ConfigureKeepalived(ctx context.Context, c client.Client, vrid1, vrid2 int) error {
+// It assigns the allocated IPs to one VIP group per VRID, renders a configuration
+// for every LB host with MASTER ownership of the groups rotating across the hosts, and returns
+// the per-host apply status; the error is set if any host failed.
+func ConfigureKeepalived(ctx context.Context, c client.Client, vrids []int) (HostApplyStatuses, error) {
+	keepalivedConfigMutex.Lock()
+	defer keepalivedConfigMutex.Unlock()
+
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
 	clusterName := GetClusterName()
-	interfaceName := os.Getenv("NGINX_NETWORK_INTERFACE")
-	authPass := os.Getenv("KEEPALIVED_AUTH_PASS")
-	if authPass == "" {
-		authPass = "YourAuthPass" // Default value; replace with secure method
+	interfaceName := GetNetworkInterface()
+
+	auth, err := GetOrCreateVRRPAuth(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := LoadKeepalivedSettings(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	groupCount, err := GetVIPGroupCount(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(vrids) != groupCount {
+		// The default group count follows the LB hosts, which change with the credentials Secret
+		if vrids, err = reallocateVRIDs(ctx, c, groupCount); err != nil {
+			return nil, err
+		}
 	}
 
 	// Load all allocated IPs to distribute them into groups
 	allocatedIPs, err := LoadAllocatedIPs(ctx, c)
 	if err != nil {
-		return fmt.Errorf("failed to load allocated IPs: %w", err)
+		return nil, fmt.Errorf("failed to load allocated IPs: %w", err)
+	}
+
+	// Leave out VIPs another cluster sharing the LB hosts already uses; the NGINX config of their
+	// Services is rejected with a VIPConflictError
+	otherClusterVIPs, err := VIPsOfOtherClusters(ctx, c)
+	if err != nil {
+		return nil, err
 	}
 
 	ips := make([]string, 0, len(allocatedIPs))
 	for ip := range allocatedIPs {
+		if _, owned := otherClusterVIPs[ip]; owned {
+			continue
+		}
 		ips = append(ips, ip)
 	}
 
-	// Sort IPs for consistent distribution
-	sort.Strings(ips)
+	// Keep VIPs in their assigned groups to avoid needless failovers
+	groups, err := AssignVIPsToGroups(ctx, c, ips, groupCount)
+	if err != nil {
+		return nil, err
+	}
 
-	// Distribute IPs into two groups equally
-	group1VIPs, group2VIPs := distributeIPsIntoGroups(ips)
+	// Render each VIP with its interface, prefix length and label from the IP pool
+	vipAttributes, err := LoadVIPAttributes(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	groups = formatVIPGroups(groups, vipAttributes)
 
-	// Generate primary and secondary configurations
-	primaryConfig, err := GenerateKeepalivedConfig(clusterName, interfaceName,
-		vrid1, vrid2, authPass, group1VIPs, group2VIPs, true)
+	profile, err := LoadHostProfile(ctx, c)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	secondaryConfig, err := GenerateKeepalivedConfig(clusterName, interfaceName,
-		vrid1, vrid2, authPass, group1VIPs, group2VIPs, false)
+	hosts, err := GetLBHosts(ctx, c)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	hosts = orderLBHostsForVRRP(hosts)
+	if err := validateVRRPGroupOverrides(settings, groupCount); err != nil {
+		return nil, err
+	}
+	if err := validateVRRPPriorities(settings, groupCount, len(hosts)); err != nil {
+		return nil, err
+	}
+	if settings.Unicast {
+		if err := validateUnicastHosts(hosts); err != nil {
+			return nil, err
+		}
 	}
 
-	// Define remote paths
-	primaryPath := fmt.Sprintf("/etc/keepalived/%s_keepalived.conf", clusterName)
-	secondaryPath := fmt.Sprintf("/etc/keepalived/%s_keepalived.conf.secondary", clusterName)
+	// Each LB host carries its own configuration
+	remotePath := clusterKeepalivedConfigPath(profile)
 
-	// Transfer configurations to NGINX server
-	if err := CopyFileToNGINXServer(ctx, c, primaryConfig, primaryPath); err != nil {
-		return fmt.Errorf("failed to copy primary Keepalived config: %w", err)
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for nodeIndex, host := range hosts {
+		instances := buildVRRPInstances(clusterName, vrids, groups, settings, nodeIndex, len(hosts))
+		config, err := GenerateKeepalivedConfig(KeepalivedConfigData{
+			ClusterName:  clusterName,
+			Node:         host,
+			Interface:    interfaceName,
+			AuthType:     auth.Type,
+			AuthPass:     auth.Pass,
+			HealthCheck:  settings.HealthCheck,
+			Unicast:      settings.Unicast,
+			UnicastPeers: unicastPeers(hosts, host),
+			Instances:    instances,
+		})
+		changed := false
+		if err == nil {
+			changed, err = applyKeepalivedConfig(ctx, c, host, profile, config, remotePath)
+		}
+		statuses = append(statuses, HostApplyStatus{Host: host, Err: err, Changed: changed})
 	}
-	if err := CopyFileToNGINXServer(ctx, c, secondaryConfig, secondaryPath); err != nil {
-		return fmt.Errorf("failed to copy secondary Keepalived config: %w", err)
+	if !statuses.Changed() {
+		return statuses, statuses.Err()
 	}
 
-	// Restart Keepalived service
-	if err := RestartKeepalived(ctx, c); err != nil {
-		return fmt.Errorf("failed to restart Keepalived: %w", err)
+	// Wait for VIPs to be updated
+	if err := SleepWithContext(ctx, 5*time.Second); err != nil {
+		return statuses, err
 	}
+	return statuses, statuses.Err()
+}
 
-	// Wait for VIPs to be updated
-	time.Sleep(5 * time.Second)
+// reallocateVRIDs allocates VRIDs for a changed number of VIP groups, keeping the cluster's
+// existing VRIDs, and returns them.
+func reallocateVRIDs(ctx context.Context, c client.Client, groupCount int) ([]int, error) {
+	if err := GetOrAllocateVRIDsOnStartup(ctx, c); err != nil {
+		return nil, fmt.Errorf("failed to reallocate VRIDs for %d VIP groups: %w", groupCount, err)
+	}
+	vrids, err := GetOrAllocateVRIDs(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(vrids) != groupCount {
+		return nil, fmt.Errorf("%d VRIDs allocated for %d VIP groups; the number of groups changed during reallocation", len(vrids), groupCount)
+	}
+	return vrids, nil
+}
+
+// applyKeepalivedConfig writes the Keepalived config to the LB host, makes sure the main
+// Keepalived config includes it, and reloads Keepalived. Hosts whose config and include are
+// unchanged are left alone, as a reload can move VIPs. If the reload fails, the previous config
+// is restored, so the next attempt does not skip the host. It reports whether Keepalived was
+// reloaded.
+func applyKeepalivedConfig(ctx context.Context, c client.Client, host LBHost, profile *HostProfile, config, remotePath string) (bool, error) {
+	current, err := FetchFileFromNGINXServer(ctx, c, host, remotePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch Keepalived config: %w", err)
+	}
+	// The file is written with a single trailing newline
+	configChanged := strings.TrimRight(current, "\n") != strings.TrimRight(config, "\n")
+	if configChanged {
+		if err := CopyFileToNGINXServer(ctx, c, host, config, remotePath); err != nil {
+			return false, fmt.Errorf("failed to copy Keepalived config: %w", err)
+		}
+	}
+
+	includeChanged, err := ensureKeepalivedInclude(ctx, c, host, profile)
+	if err != nil {
+		return false, err
+	}
+	if !configChanged && !includeChanged {
+		return false, nil
+	}
+
+	if err := ReloadKeepalived(ctx, c, host); err != nil {
+		if configChanged && current != "" {
+			if restoreErr := CopyFileToNGINXServer(ctx, c, host, current, remotePath); restoreErr != nil {
+				return false, fmt.Errorf("%w; failed to restore the previous Keepalived config: %v", err, restoreErr)
+			}
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// orderLBHostsForVRRP returns the LB hosts in VRRP node order: the primary first, followed by
+// the secondaries in the order they are listed.
+func orderLBHostsForVRRP(hosts []LBHost) []LBHost {
+	ordered := make([]LBHost, 0, len(hosts))
+	for _, host := range hosts {
+		if host.Role == LBHostRolePrimary {
+			ordered = append(ordered, host)
+		}
+	}
+	for _, host := range hosts {
+		if host.Role != LBHostRolePrimary {
+			ordered = append(ordered, host)
+		}
+	}
+	return ordered
+}
+
+// formatVIPGroups returns the groups with every VIP formatted as a virtual_ipaddress entry.
+func formatVIPGroups(groups [][]string, attributes map[string]VIPAttributes) [][]string {
+	formatted := make([][]string, len(groups))
+	for g, vips := range groups {
+		formatted[g] = make([]string, 0, len(vips))
+		for _, vip := range vips {
+			formatted[g] = append(formatted[g], attributes[vip].Format(vip))
+		}
+	}
+	return formatted
+}
+
+// validateUnicastHosts checks that every LB host address can be used as a unicast VRRP address.
+func validateUnicastHosts(hosts []LBHost) error {
+	for _, host := range hosts {
+		if net.ParseIP(host.Address) == nil {
+			return fmt.Errorf("unicast VRRP requires IP addresses, but LB host %s is not an IP address", host)
+		}
+	}
 	return nil
 }
 
-// distributeIPsIntoGroups equally distributes IPs into two VIP groups.
-// It ensures that the distribution is as balanced as possible.
-func distributeIPsIntoGroups(ips []string) ([]string, []string) {
-	group1VIPs := []string{}
-	group2VIPs := []string{}
+// unicastPeers returns the addresses of all LB hosts except node.
+func unicastPeers(hosts []LBHost, node LBHost) []string {
+	peers := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host.Address != node.Address {
+			peers = append(peers, host.Address)
+		}
+	}
+	return peers
+}
+
+// buildVRRPInstances builds the VRRP instances for the LB node at nodeIndex. Group g is owned
+// by node g mod nodeCount; the other nodes follow in rotating failover order, each with a lower
+// priority than the one before, so every node is MASTER for an equal share of the groups.
+// Groups with nopreempt or a preempt delay start as BACKUP on all nodes, as Keepalived requires;
+// the priorities still elect the owner.
+func buildVRRPInstances(clusterName string, vrids []int, groups [][]string, settings *KeepalivedSettings, nodeIndex, nodeCount int) []VRRPInstance {
+	instances := make([]VRRPInstance, 0, len(groups))
+	for g, vips := range groups {
+		groupSettings := settings.GroupSettings(g + 1)
+
+		// Position of this node in the group's failover order; 0 is the MASTER
+		rank := (nodeIndex - g%nodeCount + nodeCount) % nodeCount
 
-	for i, ip := range ips {
-		if i%2 == 0 {
-			group1VIPs = append(group1VIPs, ip)
-		} else {
-			group2VIPs = append(group2VIPs, ip)
+		state := "BACKUP"
+		if rank == 0 && !groupSettings.NoPreempt && groupSettings.PreemptDelay == 0 {
+			state = "MASTER"
 		}
+
+		// Keepalived can only fit about 20 addresses into an advert
+		var excluded []string
+		if len(vips) > settings.MaxAdvertisedVIPs {
+			vips, excluded = vips[:settings.MaxAdvertisedVIPs], vips[settings.MaxAdvertisedVIPs:]
+		}
+
+		instances = append(instances, VRRPInstance{
+			Name:            fmt.Sprintf("VI_%s_GROUP%d", clusterName, g+1),
+			State:           state,
+			VirtualRouterID: vrids[g],
+			Priority:        groupSettings.PriorityBase + nodeCount - 1 - rank,
+			AdvertInt:       groupSettings.AdvertInt,
+			NoPreempt:       groupSettings.NoPreempt,
+			PreemptDelay:    groupSettings.PreemptDelay,
+			VIPs:            vips,
+			ExcludedVIPs:    excluded,
+		})
 	}
-	return group1VIPs, group2VIPs
+	return instances
 }
 
-// GenerateKeepalivedConfig creates the Keepalived configuration content from the template.
-func GenerateKeepalivedConfig(clusterName, interfaceName string, vrid1,
-	vrid2 int, authPass string, group1VIPs, group2VIPs []string, isPrimary bool) (string, error) {
+// validateVRRPPriorities checks that the highest priority of every group stays below 255, which
+// Keepalived reserves for the owner of the addresses.
+func validateVRRPPriorities(settings *KeepalivedSettings, groupCount, nodeCount int) error {
+	for group := 1; group <= groupCount; group++ {
+		if top := settings.GroupSettings(group).PriorityBase + nodeCount - 1; top > 254 {
+			return fmt.Errorf("priority %d of VIP group %d exceeds 254; lower priority_base", top, group)
+		}
+	}
+	return nil
+}
 
-	var tmplContent string
-	if isPrimary {
-		tmplContent = keepalivedPrimaryTemplate
-	} else {
-		tmplContent = keepalivedSecondaryTemplate
+// validateVRRPGroupOverrides checks that every per-group VRRP override names an existing group,
+// so an override of a group that does not exist is reported instead of silently ignored.
+func validateVRRPGroupOverrides(settings *KeepalivedSettings, groupCount int) error {
+	for group := range settings.Groups {
+		if group > groupCount {
+			return fmt.Errorf("group%d_ settings override VIP group %d, but there are only %d groups", group, group, groupCount)
+		}
 	}
+	return nil
+}
 
-	tmpl, err := template.New("keepalived").Parse(tmplContent)
+// GenerateKeepalivedConfig creates the Keepalived configuration content for an LB node from the template.
+func GenerateKeepalivedConfig(data KeepalivedConfigData) (string, error) {
+	tmpl, err := template.New("keepalived").Parse(keepalivedTemplate)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse Keepalived template: %w", err)
 	}
 
-	data := struct {
-		ClusterName      string
-		Interface        string
-		VirtualRouterID1 int
-		VirtualRouterID2 int
-		AuthPass         string
-		Group1VIPs       []string
-		Group2VIPs       []string
-	}{
-		ClusterName:      clusterName,
-		Interface:        interfaceName,
-		VirtualRouterID1: vrid1,
-		VirtualRouterID2: vrid2,
-		AuthPass:         authPass,
-		Group1VIPs:       group1VIPs,
-		Group2VIPs:       group2VIPs,
-	}
-
 	var renderedConfig bytes.Buffer
 	if err := tmpl.Execute(&renderedConfig, data); err != nil {
 		return "", fmt.Errorf("failed to execute Keepalived template: %w", err)
@@ -141,10 +356,39 @@ func GenerateKeepalivedConfig(clusterName, interfaceName string, vrid1,
 	return renderedConfig.String(), nil
 }
 
-// RestartKeepalived restarts the Keepalived service on the NGINX server via SSH.
-func RestartKeepalived(ctx context.Context, c client.Client) error {
-	command := "sudo systemctl restart keepalived"
-	if err := ExecuteSSHCommand(ctx, c, command); err != nil {
+// ReloadKeepalived validates the Keepalived configuration on the LB host if a validate command is
+// configured, and reloads Keepalived, starting it if it is not running.
+func ReloadKeepalived(ctx context.Context, c client.Client, host LBHost) error {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if profile.KeepalivedValidateCommand != "" {
+		if err := ExecuteSSHCommand(ctx, c, host, profile.KeepalivedValidateCommand); err != nil {
+			return fmt.Errorf("invalid Keepalived configuration: %w", err)
+		}
+	}
+	if err := ExecuteSSHCommand(ctx, c, host, profile.KeepalivedReloadCommand); err != nil {
+		return fmt.Errorf("failed to reload Keepalived: %w", err)
+	}
+	return nil
+}
+
+// RestartKeepalived validates the Keepalived configuration, if a validate command is configured,
+// and restarts the service on the LB host via SSH using the commands from the host profile.
+func RestartKeepalived(ctx context.Context, c client.Client, host LBHost) error {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if profile.KeepalivedValidateCommand != "" {
+		if err := ExecuteSSHCommand(ctx, c, host, profile.KeepalivedValidateCommand); err != nil {
+			return fmt.Errorf("invalid Keepalived configuration: %w", err)
+		}
+	}
+	if err := ExecuteSSHCommand(ctx, c, host, profile.KeepalivedRestartCommand); err != nil {
 		return fmt.Errorf("failed to restart Keepalived service: %w", err)
 	}
 	return nil
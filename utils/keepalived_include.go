@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clusterKeepalivedConfigPath returns the path of the cluster's Keepalived config on the LB hosts.
+func clusterKeepalivedConfigPath(profile *HostProfile) string {
+	return profile.KeepalivedConfigPath(fmt.Sprintf("%s_keepalived.conf", GetClusterName()))
+}
+
+// ensureKeepalivedInclude adds a block including the cluster's Keepalived config to the main
+// Keepalived config on the LB host, or fixes it. It reports whether the main config changed.
+func ensureKeepalivedInclude(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) (bool, error) {
+	include := fmt.Sprintf("include %s", clusterKeepalivedConfigPath(profile))
+	changed, err := updateManagedBlock(ctx, c, host, profile.KeepalivedMainConfig, GetClusterName(), include, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to include the cluster's Keepalived config: %w", err)
+	}
+	return changed, nil
+}
+
+// removeKeepalivedInclude removes the cluster's include block from the main Keepalived config on
+// the LB host.
+func removeKeepalivedInclude(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) (bool, error) {
+	changed, err := updateManagedBlock(ctx, c, host, profile.KeepalivedMainConfig, GetClusterName(), "", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove the cluster's Keepalived include: %w", err)
+	}
+	return changed, nil
+}
+
+// EnsureKeepalivedIncludes verifies on every LB host that the main Keepalived config includes the
+// cluster's config, adding the include block if it is missing and reloading Keepalived to load
+// it. Hosts without a cluster config yet are skipped; the include is added with the first config.
+func EnsureKeepalivedIncludes(ctx context.Context, c client.Client) (HostApplyStatuses, error) {
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := clusterKeepalivedConfigPath(profile)
+	checkConfig := profile.Escalate(fmt.Sprintf("sh -c '[ -f %s ] && echo exists || true'", configPath))
+
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for _, host := range hosts {
+		statuses = append(statuses, HostApplyStatus{
+			Host: host,
+			Err:  ensureKeepalivedIncludeOnHost(ctx, c, host, profile, checkConfig),
+		})
+	}
+	return statuses, statuses.Err()
+}
+
+// ensureKeepalivedIncludeOnHost adds the include block on the LB host if the cluster's config
+// exists there, and reloads Keepalived if the main config changed.
+func ensureKeepalivedIncludeOnHost(ctx context.Context, c client.Client, host LBHost, profile *HostProfile, checkConfig string) error {
+	output, err := ExecuteSSHCommandOutput(ctx, c, host, checkConfig)
+	if err != nil {
+		return fmt.Errorf("failed to check for the cluster's Keepalived config: %w", err)
+	}
+	if strings.TrimSpace(output) != "exists" {
+		return nil
+	}
+
+	changed, err := ensureKeepalivedInclude(ctx, c, host, profile)
+	if err != nil || !changed {
+		return err
+	}
+	return ReloadKeepalived(ctx, c, host)
+}
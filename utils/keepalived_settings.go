@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// groupSettingKeyPattern matches the keys of per-group VRRP settings, e.g. "group2_nopreempt".
+var groupSettingKeyPattern = regexp.MustCompile(`^group(\d+)_(nopreempt|preempt_delay|advert_int|priority_base)$`)
+
+// healthCheckUserPattern matches the vrrp_script user: a user name and an optional group name.
+var healthCheckUserPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+( [A-Za-z0-9._-]+)?$`)
+
+// KeepalivedSettings holds the Keepalived options from the keepalived-config ConfigMap.
+type KeepalivedSettings struct {
+	// VIPGroups is the number of VRRP instances the VIPs are spread across.
+	// Zero selects one group per LB host, with a minimum of two.
+	VIPGroups int
+	// RebalanceThreshold is the difference in VIP count between the largest and smallest group
+	// above which VIPs are moved between groups. Zero disables automatic rebalancing.
+	RebalanceThreshold int
+	// AuthType is the VRRP authentication type, PASS or AH.
+	AuthType string
+	// HealthCheck is the NGINX health check tracked by every VRRP instance; nil disables it.
+	HealthCheck *HealthCheck
+	// Unicast sends VRRP adverts to the other LB hosts directly instead of via multicast.
+	Unicast bool
+	// MaxAdvertisedVIPs is the most VIPs a group advertises; larger groups advertise their first
+	// MaxAdvertisedVIPs VIPs and manage the rest as excluded addresses.
+	MaxAdvertisedVIPs int
+	// VRRP holds the VRRP instance settings of all groups without an override in Groups.
+	VRRP VRRPGroupSettings
+	// Groups holds the per-group overrides, keyed by 1-based group number.
+	Groups map[int]VRRPGroupSettings
+}
+
+// VRRPGroupSettings are the preemption, advertisement and priority settings of a VRRP instance.
+type VRRPGroupSettings struct {
+	// NoPreempt keeps a recovered higher-priority host from taking back the VIPs.
+	NoPreempt bool
+	// PreemptDelay is the number of seconds a recovered host waits before taking back the VIPs.
+	PreemptDelay int
+	AdvertInt    int
+	// PriorityBase is the priority of the last host in the failover order; each host before it
+	// gets one more.
+	PriorityBase int
+}
+
+// GroupSettings returns the VRRP settings of the 1-based group.
+func (s *KeepalivedSettings) GroupSettings(group int) VRRPGroupSettings {
+	if groupSettings, exists := s.Groups[group]; exists {
+		return groupSettings
+	}
+	return s.VRRP
+}
+
+// HealthCheck is a Keepalived vrrp_script checking NGINX on the LB host. A failing check lowers
+// the instance priority by Weight, or puts the instance into FAULT state when Weight is zero.
+// The check runs as User, as Keepalived otherwise picks a user depending on its script security.
+type HealthCheck struct {
+	Name     string
+	Command  string
+	User     string
+	Interval int
+	Weight   int
+	Fall     int
+	Rise     int
+}
+
+// LoadKeepalivedSettings loads the Keepalived settings from the ConfigMap, falling back to
+// defaults when the ConfigMap or a key is missing.
+func LoadKeepalivedSettings(ctx context.Context, c client.Client) (*KeepalivedSettings, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Name: "keepalived-config", Namespace: "nginx-lb-operator-system"}, configMap)
+	if err != nil && client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("failed to load Keepalived settings: %w", err)
+	}
+	data := configMap.Data
+
+	settings := &KeepalivedSettings{}
+	if settings.VIPGroups, err = parseSettingInt(data, "vip_groups", 0); err != nil {
+		return nil, err
+	}
+	if settings.VIPGroups < 0 || settings.VIPGroups > 255 {
+		return nil, fmt.Errorf("vip_groups must be between 1 and 255, or 0 for one group per LB host with a minimum of two")
+	}
+	if settings.RebalanceThreshold, err = parseSettingInt(data, "rebalance_threshold", 2); err != nil {
+		return nil, err
+	}
+	if settings.RebalanceThreshold < 0 {
+		return nil, fmt.Errorf("rebalance_threshold must not be negative")
+	}
+
+	settings.AuthType = strings.ToUpper(strings.TrimSpace(data["auth_type"]))
+	if settings.AuthType == "" {
+		settings.AuthType = VRRPAuthTypePASS
+	}
+	if settings.AuthType != VRRPAuthTypePASS && settings.AuthType != VRRPAuthTypeAH {
+		return nil, fmt.Errorf("invalid auth_type '%s': expected %s or %s", data["auth_type"], VRRPAuthTypePASS, VRRPAuthTypeAH)
+	}
+
+	if settings.HealthCheck, err = parseHealthCheck(data); err != nil {
+		return nil, err
+	}
+	if settings.Unicast, err = parseSettingBool(data, "unicast", false); err != nil {
+		return nil, err
+	}
+	if settings.MaxAdvertisedVIPs, err = parseSettingInt(data, "max_advertised_vips", 20); err != nil {
+		return nil, err
+	}
+	if settings.MaxAdvertisedVIPs < 1 || settings.MaxAdvertisedVIPs > 20 {
+		return nil, fmt.Errorf("max_advertised_vips must be between 1 and 20")
+	}
+
+	// Cluster-wide VRRP settings, overridable per group with keys prefixed by "group<N>_"
+	defaults := VRRPGroupSettings{AdvertInt: 1, PriorityBase: 100}
+	if settings.VRRP, err = parseVRRPGroupSettings(data, "", defaults); err != nil {
+		return nil, err
+	}
+	settings.Groups = make(map[int]VRRPGroupSettings)
+	for key := range data {
+		match := groupSettingKeyPattern.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		group, _ := strconv.Atoi(match[1])
+		if _, parsed := settings.Groups[group]; parsed {
+			continue
+		}
+		if group < 1 || group > 255 {
+			return nil, fmt.Errorf("invalid group number in %s", key)
+		}
+		if settings.Groups[group], err = parseVRRPGroupSettings(data, fmt.Sprintf("group%d_", group), settings.VRRP); err != nil {
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+// GetVIPGroupCount returns the number of VIP groups, defaulting to one group per LB host
+// with a minimum of two.
+func GetVIPGroupCount(ctx context.Context, c client.Client) (int, error) {
+	settings, err := LoadKeepalivedSettings(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	if settings.VIPGroups > 0 {
+		return settings.VIPGroups, nil
+	}
+
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	if len(hosts) < 2 {
+		return 2, nil
+	}
+	return len(hosts), nil
+}
+
+// parseVRRPGroupSettings parses the VRRP settings whose keys start with prefix, using defaults for
+// keys that are not set.
+func parseVRRPGroupSettings(data map[string]string, prefix string, defaults VRRPGroupSettings) (VRRPGroupSettings, error) {
+	settings := defaults
+	var err error
+	if settings.NoPreempt, err = parseSettingBool(data, prefix+"nopreempt", defaults.NoPreempt); err != nil {
+		return settings, err
+	}
+	if settings.PreemptDelay, err = parseSettingInt(data, prefix+"preempt_delay", defaults.PreemptDelay); err != nil {
+		return settings, err
+	}
+	if settings.AdvertInt, err = parseSettingInt(data, prefix+"advert_int", defaults.AdvertInt); err != nil {
+		return settings, err
+	}
+	if settings.PriorityBase, err = parseSettingInt(data, prefix+"priority_base", defaults.PriorityBase); err != nil {
+		return settings, err
+	}
+
+	if settings.PreemptDelay < 0 || settings.PreemptDelay > 1000 {
+		return settings, fmt.Errorf("%spreempt_delay must be between 0 and 1000", prefix)
+	}
+	if settings.AdvertInt < 1 || settings.AdvertInt > 255 {
+		return settings, fmt.Errorf("%sadvert_int must be between 1 and 255", prefix)
+	}
+	if settings.PriorityBase < 1 || settings.PriorityBase > 254 {
+		return settings, fmt.Errorf("%spriority_base must be between 1 and 254", prefix)
+	}
+	return settings, nil
+}
+
+// parseHealthCheck parses the NGINX health check settings. The check defaults to
+// "/usr/bin/pgrep -x nginx" run as root; health_check_port switches it to a TCP connect to the
+// port on the LB host. Keepalived runs scripts without a shell or PATH lookup, so the default
+// commands use absolute paths.
+func parseHealthCheck(data map[string]string) (*HealthCheck, error) {
+	enabled, err := parseSettingBool(data, "health_check", true)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	check := &HealthCheck{
+		Name:    fmt.Sprintf("chk_nginx_%s", GetClusterName()),
+		Command: "/usr/bin/pgrep -x nginx",
+		User:    "root",
+	}
+	port, err := parseSettingInt(data, "health_check_port", 0)
+	if err != nil {
+		return nil, err
+	}
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("health_check_port must be between 1 and 65535")
+	}
+	if port > 0 {
+		check.Command = fmt.Sprintf("/bin/bash -c '</dev/tcp/127.0.0.1/%d'", port)
+	}
+	if command := strings.TrimSpace(data["health_check_command"]); command != "" {
+		if strings.Contains(command, `"`) {
+			return nil, fmt.Errorf("health_check_command must not contain double quotes")
+		}
+		check.Command = command
+	}
+	if user := strings.TrimSpace(data["health_check_user"]); user != "" {
+		if !healthCheckUserPattern.MatchString(user) {
+			return nil, fmt.Errorf("health_check_user must be a user name, optionally followed by a group name")
+		}
+		check.User = user
+	}
+
+	if check.Interval, err = parseSettingInt(data, "health_check_interval", 2); err != nil {
+		return nil, err
+	}
+	if check.Weight, err = parseSettingInt(data, "health_check_weight", 0); err != nil {
+		return nil, err
+	}
+	if check.Fall, err = parseSettingInt(data, "health_check_fall", 2); err != nil {
+		return nil, err
+	}
+	if check.Rise, err = parseSettingInt(data, "health_check_rise", 2); err != nil {
+		return nil, err
+	}
+	if check.Interval < 1 || check.Fall < 1 || check.Rise < 1 {
+		return nil, fmt.Errorf("health_check_interval, health_check_fall and health_check_rise must be at least 1")
+	}
+	if check.Weight < -253 || check.Weight > 253 {
+		return nil, fmt.Errorf("health_check_weight must be between -253 and 253")
+	}
+
+	return check, nil
+}
+
+// parseSettingBool parses a boolean setting, returning the default if the key is not set.
+func parseSettingBool(data map[string]string, key string, defaultValue bool) (bool, error) {
+	value := strings.TrimSpace(data[key])
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value '%s' for %s: %w", value, key, err)
+	}
+	return parsed, nil
+}
+
+// parseSettingInt parses an integer setting, returning the default if the key is not set.
+func parseSettingInt(data map[string]string, key string, defaultValue int) (int, error) {
+	value := strings.TrimSpace(data[key])
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s' for %s: %w", value, key, err)
+	}
+	return parsed, nil
+}
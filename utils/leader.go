@@ -0,0 +1,23 @@
+package utils
+
+// elected is closed once this replica becomes the leader. It is set by the manager; the
+// subcommands run alone and act as the leader.
+var elected <-chan struct{}
+
+// SetElected sets the channel closed when this replica becomes the leader, see manager.Elected.
+func SetElected(ch <-chan struct{}) {
+	elected = ch
+}
+
+// isLeader reports whether this replica is the leader, or runs without a manager.
+func isLeader() bool {
+	if elected == nil {
+		return true
+	}
+	select {
+	case <-elected:
+		return true
+	default:
+		return false
+	}
+}
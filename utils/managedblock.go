@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// managedBlockMarkers returns the comment lines delimiting the cluster's block in a config file
+// shared with the operators of other clusters, e.g. "# BEGIN nginx-lb-operator harso-master".
+func managedBlockMarkers(name string) (string, string) {
+	return fmt.Sprintf("# BEGIN nginx-lb-operator %s", name), fmt.Sprintf("# END nginx-lb-operator %s", name)
+}
+
+// setManagedBlock returns content with the named block replaced by body in place, or with the
+// block appended if it is missing. An empty body removes the block along with the blank line
+// separating it. A BEGIN marker without an END marker is replaced on its own, so the lines after
+// it are kept. Trailing newlines are dropped.
+func setManagedBlock(content, name, body string) string {
+	begin, end := managedBlockMarkers(name)
+
+	var block []string
+	if body != "" {
+		block = []string{begin, strings.TrimRight(body, "\n"), end}
+	}
+
+	var lines, skipped []string
+	inBlock, found := false, false
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		switch {
+		case !inBlock && strings.TrimSpace(line) == begin:
+			inBlock, found = true, true
+			if block == nil && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+				lines = lines[:len(lines)-1]
+			}
+			lines = append(lines, block...)
+		case inBlock && strings.TrimSpace(line) == end:
+			inBlock, skipped = false, nil
+		case inBlock:
+			skipped = append(skipped, line)
+		default:
+			lines = append(lines, line)
+		}
+	}
+	// The block was not terminated, so the lines after its BEGIN marker are not part of it
+	lines = append(lines, skipped...)
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if !found && block != nil {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// updateManagedBlock sets the named block of the file on the LB host to body under a lock, as
+// other clusters' operators edit the same file. If check is set, it is called with the current
+// content under the lock first; the file is left unchanged if it returns false or an error. It
+// reports whether the file changed.
+func updateManagedBlock(ctx context.Context, c client.Client, host LBHost, filePath, name, body string, check func(content string) (bool, error)) (bool, error) {
+	changed := false
+	err := withRemoteLock(ctx, c, host, filePath+".lock", func() error {
+		content, err := FetchFileFromNGINXServer(ctx, c, host, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", filePath, err)
+		}
+
+		// The file is written with a single trailing newline
+		updated := setManagedBlock(content, name, body)
+		if updated == strings.TrimRight(content, "\n") {
+			return nil
+		}
+		if check != nil {
+			if proceed, err := check(content); err != nil || !proceed {
+				return err
+			}
+		}
+		if err := CopyFileToNGINXServer(ctx, c, host, updated, filePath); err != nil {
+			return fmt.Errorf("failed to update %s: %w", filePath, err)
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
@@ -0,0 +1,88 @@
+package utils
+
+import "testing"
+
+func TestSetManagedBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		body    string
+		want    string
+	}{
+		{
+			name: "empty file",
+			body: "include /etc/keepalived/a.conf",
+			want: "# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "block missing",
+			content: "global_defs {\n}\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "global_defs {\n}\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "block present",
+			content: "global_defs {\n}\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/old.conf\n# END nginx-lb-operator test\n\n# other\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "global_defs {\n}\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test\n\n# other",
+		},
+		{
+			name:    "block unchanged",
+			content: "# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "other cluster's block kept",
+			content: "# BEGIN nginx-lb-operator other\ninclude /etc/keepalived/b.conf\n# END nginx-lb-operator other\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "# BEGIN nginx-lb-operator other\ninclude /etc/keepalived/b.conf\n# END nginx-lb-operator other\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "removal",
+			content: "global_defs {\n}\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test\n",
+			want:    "global_defs {\n}",
+		},
+		{
+			name:    "removal between blocks",
+			content: "# first\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test\n\n# last\n",
+			want:    "# first\n\n# last",
+		},
+		{
+			name:    "removal of missing block",
+			content: "global_defs {\n}\n",
+			want:    "global_defs {\n}",
+		},
+		{
+			name:    "trailing blank lines dropped",
+			content: "global_defs {\n}\n\n\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "global_defs {\n}\n\n# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "indented markers",
+			content: "  # BEGIN nginx-lb-operator test\ninclude /etc/keepalived/old.conf\n  # END nginx-lb-operator test\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test",
+		},
+		{
+			name:    "unterminated block keeps the rest of the file",
+			content: "# BEGIN nginx-lb-operator test\nglobal_defs {\n}\n",
+			body:    "include /etc/keepalived/a.conf",
+			want:    "# BEGIN nginx-lb-operator test\ninclude /etc/keepalived/a.conf\n# END nginx-lb-operator test\nglobal_defs {\n}",
+		},
+		{
+			name:    "unterminated block removal keeps the rest of the file",
+			content: "# first\n\n# BEGIN nginx-lb-operator test\nglobal_defs {\n}\n",
+			want:    "# first\nglobal_defs {\n}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setManagedBlock(tt.content, "test", tt.body); got != tt.want {
+				t.Errorf("setManagedBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,26 +16,62 @@ import (
 //go:embed templates/nginx.conf.tmpl
 var nginxTemplate string
 
-// ConfigureNGINX generates and updates the NGINX configuration for the service.
-func ConfigureNGINX(ctx context.Context, c client.Client, service *corev1.Service, ip string) error {
+// ConfigureNGINX generates the NGINX configuration for the service and applies it to every LB host.
+// It returns the per-host apply status; the error is set if any host failed.
+func ConfigureNGINX(ctx context.Context, c client.Client, service *corev1.Service, ip string) (HostApplyStatuses, error) {
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
 	nodeIPs, err := GetServiceNodeIPs(ctx, c, service)
 	if err != nil {
-		return fmt.Errorf("failed to get node IPs for service %s/%s: %w", service.Namespace, service.Name, err)
+		return nil, fmt.Errorf("failed to get node IPs for service %s/%s: %w", service.Namespace, service.Name, err)
 	}
 
 	nginxConfig, err := GenerateNGINXConfig(service, nodeIPs, ip)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
 	}
 
-	remotePath := fmt.Sprintf("/etc/nginx/conf.d/vip-%s-%s-%s.conf",
-		GetClusterName(), service.Namespace, service.Name)
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to listen on a VIP another cluster sharing the LB hosts uses
+	if err := ClaimVIPListener(ctx, c, service, ip); err != nil {
+		return nil, err
+	}
 
-	if err := CopyFileToNGINXServer(ctx, c, nginxConfig, remotePath); err != nil {
+	remotePath := profile.NginxConfigPath(nginxConfigFilename(service))
+
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for _, host := range hosts {
+		statuses = append(statuses, HostApplyStatus{
+			Host: host,
+			Err:  applyNGINXConfig(ctx, c, host, profile, nginxConfig, remotePath),
+		})
+	}
+	return statuses, statuses.Err()
+}
+
+// applyNGINXConfig writes the NGINX config to the cluster's stream config directory on the LB host
+// and reloads NGINX.
+func applyNGINXConfig(ctx context.Context, c client.Client, host LBHost, profile *HostProfile, nginxConfig, remotePath string) error {
+	if err := createNGINXClusterConfigDir(ctx, c, host, profile); err != nil {
+		return err
+	}
+
+	if err := CopyFileToNGINXServer(ctx, c, host, nginxConfig, remotePath); err != nil {
 		return fmt.Errorf("failed to copy NGINX config to server: %w", err)
 	}
 
-	if err := ReloadNGINX(ctx, c); err != nil {
+	if err := ReloadNGINX(ctx, c, host); err != nil {
 		return fmt.Errorf("failed to reload NGINX: %w", err)
 	}
 
@@ -77,26 +113,72 @@ func GenerateNGINXConfig(service *corev1.Service, nodeIPs []string, ip string) (
 	return renderedConfig.String(), nil
 }
 
-// RemoveNGINXConfig removes the NGINX configuration for the specified service.
-func RemoveNGINXConfig(ctx context.Context, c client.Client, service *corev1.Service) error {
-	remotePath := fmt.Sprintf("/etc/nginx/conf.d/vip-%s-%s-%s.conf",
-		GetClusterName(), service.Namespace, service.Name)
+// RemoveNGINXConfig removes the NGINX configuration for the specified service from every LB host.
+// It returns the per-host status; the error is set if any host failed.
+func RemoveNGINXConfig(ctx context.Context, c client.Client, service *corev1.Service) (HostApplyStatuses, error) {
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := RemoveFileFromNGINXServer(ctx, c, remotePath); err != nil {
-		return fmt.Errorf("failed to remove NGINX config from server: %w", remotePath, err)
+	remotePath := profile.NginxConfigPath(nginxConfigFilename(service))
+
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for _, host := range hosts {
+		statuses = append(statuses, HostApplyStatus{
+			Host: host,
+			Err:  removeNGINXConfig(ctx, c, host, remotePath),
+		})
+	}
+	if err := statuses.Err(); err != nil {
+		return statuses, err
 	}
 
-	if err := ReloadNGINX(ctx, c); err != nil {
+	if err := ReleaseVIPListener(ctx, c, service); err != nil {
+		return statuses, err
+	}
+	return statuses, nil
+}
+
+// removeNGINXConfig removes the NGINX config from the LB host and reloads NGINX.
+func removeNGINXConfig(ctx context.Context, c client.Client, host LBHost, remotePath string) error {
+	if err := RemoveFileFromNGINXServer(ctx, c, host, remotePath); err != nil {
+		return fmt.Errorf("failed to remove NGINX config %s from server: %w", remotePath, err)
+	}
+
+	if err := ReloadNGINX(ctx, c, host); err != nil {
 		return fmt.Errorf("failed to reload NGINX after removing config: %w", err)
 	}
 
 	return nil
 }
 
-// ReloadNGINX reloads the NGINX service on the server via SSH.
-func ReloadNGINX(ctx context.Context, c client.Client) error {
-	command := "sudo nginx -s reload"
-	if err := ExecuteSSHCommand(ctx, c, command); err != nil {
+// nginxConfigFilename returns the name of the NGINX config file for the service.
+func nginxConfigFilename(service *corev1.Service) string {
+	return fmt.Sprintf("vip-%s-%s-%s.conf", GetClusterName(), service.Namespace, service.Name)
+}
+
+// ReloadNGINX validates the NGINX configuration and reloads the service on the LB host via SSH,
+// using the commands from the host profile.
+func ReloadNGINX(ctx context.Context, c client.Client, host LBHost) error {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err := ExecuteSSHCommand(ctx, c, host, profile.NginxValidateCommand); err != nil {
+		return fmt.Errorf("invalid NGINX configuration: %w", err)
+	}
+	if err := ExecuteSSHCommand(ctx, c, host, profile.NginxReloadCommand); err != nil {
 		return fmt.Errorf("failed to reload NGINX: %w", err)
 	}
 	return nil
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nginxStreamIncludeBlock names the block including the stream configs in the main NGINX config.
+// NGINX allows a single stream block, so the block is shared by the operators of all clusters on
+// the LB host, each of which writes the same content. Cluster names cannot contain spaces.
+const nginxStreamIncludeBlock = "stream include"
+
+// legacyNGINXConfigDir is where earlier versions wrote the NGINX configs by default.
+const legacyNGINXConfigDir = "/etc/nginx/conf.d"
+
+var streamBlockPattern = regexp.MustCompile(`(?m)^\s*stream\s*\{`)
+
+// nginxStreamInclude returns the include directive loading the stream configs of all clusters.
+func nginxStreamInclude(profile *HostProfile) string {
+	return fmt.Sprintf("include %s;", path.Join(profile.NginxConfigDir, "*", "*.conf"))
+}
+
+// ensureNGINXStreamInclude adds a stream block including the stream configs to the main NGINX
+// config on the LB host, or fixes it. A stream block maintained by hand is accepted if it already
+// includes the stream configs. The current NGINX config must be valid before the block is
+// written, so a config that fails afterwards is known to be caused by the block. It reports
+// whether the main config changed.
+func ensureNGINXStreamInclude(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) (bool, error) {
+	include := nginxStreamInclude(profile)
+	check := func(content string) (bool, error) {
+		if unmanaged := setManagedBlock(content, nginxStreamIncludeBlock, ""); streamBlockPattern.MatchString(unmanaged) {
+			if strings.Contains(unmanaged, include) {
+				return false, nil
+			}
+			return false, fmt.Errorf("%s already has a stream block; add '%s' to it", profile.NginxMainConfig, include)
+		}
+		if err := ExecuteSSHCommand(ctx, c, host, profile.NginxValidateCommand); err != nil {
+			return false, fmt.Errorf("invalid NGINX configuration before adding the stream include: %w", err)
+		}
+		return true, nil
+	}
+
+	body := fmt.Sprintf("stream {\n    %s\n}", include)
+	changed, err := updateManagedBlock(ctx, c, host, profile.NginxMainConfig, nginxStreamIncludeBlock, body, check)
+	if err != nil {
+		return false, fmt.Errorf("failed to include the stream configs: %w", err)
+	}
+	return changed, nil
+}
+
+// EnsureNGINXStreamIncludes verifies on every LB host that the cluster's stream config directory
+// exists and that the main NGINX config includes it in a stream block, adding the block if it is
+// missing and reloading NGINX. NGINX configs written to conf.d by earlier versions are removed.
+func EnsureNGINXStreamIncludes(ctx context.Context, c client.Client) (HostApplyStatuses, error) {
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return nil, err
+	}
+
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for _, host := range hosts {
+		statuses = append(statuses, HostApplyStatus{
+			Host: host,
+			Err:  ensureNGINXStreamIncludeOnHost(ctx, c, host, profile),
+		})
+	}
+	return statuses, statuses.Err()
+}
+
+// ensureNGINXStreamIncludeOnHost creates the cluster's stream config directory and the stream
+// include on the LB host and reloads NGINX if the include was added. If NGINX rejects the config,
+// the include is removed again, as the check before writing it proved that this call added it.
+// The cluster's legacy NGINX configs are removed only once the include is in place, so a host
+// that cannot take the include keeps serving from them.
+func ensureNGINXStreamIncludeOnHost(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) error {
+	if err := createNGINXClusterConfigDir(ctx, c, host, profile); err != nil {
+		return err
+	}
+
+	changed, err := ensureNGINXStreamInclude(ctx, c, host, profile)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := ReloadNGINX(ctx, c, host); err != nil {
+			if _, removeErr := updateManagedBlock(ctx, c, host, profile.NginxMainConfig, nginxStreamIncludeBlock, "", nil); removeErr != nil {
+				return fmt.Errorf("%w; failed to remove the stream include again: %v", err, removeErr)
+			}
+			return err
+		}
+	}
+
+	legacyConfigs, err := listLegacyNGINXConfigs(ctx, c, host, profile)
+	if err != nil || len(legacyConfigs) == 0 {
+		return err
+	}
+	for _, file := range legacyConfigs {
+		if err := RemoveFileFromNGINXServer(ctx, c, host, file); err != nil {
+			return fmt.Errorf("failed to remove legacy NGINX config: %w", err)
+		}
+	}
+	return ReloadNGINX(ctx, c, host)
+}
+
+// listLegacyNGINXConfigs returns the cluster's NGINX configs that earlier versions wrote as
+// vip-<cluster>-<namespace>-<name>.conf to /etc/nginx/conf.d, which stock NGINX loads inside
+// http {}, or to the configured NGINX config directory itself. Other clusters whose name starts
+// with this cluster's name and a dash match the file pattern as well, so only files whose
+// upstream is named after this cluster are returned.
+func listLegacyNGINXConfigs(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) ([]string, error) {
+	clusterName := GetClusterName()
+	pattern := fmt.Sprintf("vip-%s-*.conf", clusterName)
+	command := profile.Escalate(fmt.Sprintf(`sh -c 'grep -ls "^upstream %s_" %s %s || true'`,
+		clusterName, path.Join(legacyNGINXConfigDir, pattern), path.Join(profile.NginxConfigDir, pattern)))
+
+	output, err := ExecuteSSHCommandOutput(ctx, c, host, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list legacy NGINX configs: %w", err)
+	}
+	var files []string
+	for _, file := range strings.Split(output, "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// createNGINXClusterConfigDir creates the cluster's stream config directory on the LB host.
+func createNGINXClusterConfigDir(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) error {
+	if err := ExecuteSSHCommand(ctx, c, host, profile.Escalate(fmt.Sprintf("mkdir -p %s", profile.NginxClusterConfigDir()))); err != nil {
+		return fmt.Errorf("failed to create NGINX config directory %s: %w", profile.NginxClusterConfigDir(), err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	nginxVersionPattern      = regexp.MustCompile(`nginx version: (\S+)`)
+	keepalivedVersionPattern = regexp.MustCompile(`Keepalived (v\S+)`)
+)
+
+// PreflightResult is the outcome of one preflight check on an LB host. Detail describes what was
+// found, or why the check failed when Err is set.
+type PreflightResult struct {
+	Host   LBHost
+	Check  string
+	Detail string
+	Err    error
+}
+
+// PreflightResults are the preflight results of all LB hosts.
+type PreflightResults []PreflightResult
+
+// Err returns the combined error of the failed checks, or nil if all passed.
+func (r PreflightResults) Err() error {
+	var errs []error
+	for _, result := range r {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", result.Host, result.Check, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String summarizes the results, e.g. "10.1.1.52 (primary): ssh ok, escalation ok, nginx failed, ...".
+func (r PreflightResults) String() string {
+	var hosts []string
+	checks := make(map[string][]string)
+	for _, result := range r {
+		key := result.Host.String()
+		if _, seen := checks[key]; !seen {
+			hosts = append(hosts, key)
+		}
+		status := "ok"
+		if result.Err != nil {
+			status = "failed"
+		}
+		checks[key] = append(checks[key], fmt.Sprintf("%s %s", result.Check, status))
+	}
+
+	parts := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		parts = append(parts, fmt.Sprintf("%s: %s", host, strings.Join(checks[host], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// RunPreflight checks every LB host for what the operator needs: privilege escalation, NGINX
+// with the stream module, Keepalived, net.ipv4.ip_nonlocal_bind=1 and writable config paths.
+// It changes nothing on the hosts. The error is only set if the hosts could not be determined;
+// failed checks are reported in the results.
+func RunPreflight(ctx context.Context, c client.Client) (PreflightResults, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var results PreflightResults
+	for _, host := range hosts {
+		results = append(results, preflightHost(ctx, c, host, profile)...)
+	}
+	return results, nil
+}
+
+// preflightHost runs the preflight checks on the LB host. If the host cannot be reached, the
+// remaining checks are skipped.
+func preflightHost(ctx context.Context, c client.Client, host LBHost, profile *HostProfile) PreflightResults {
+	run := func(command string) (string, error) {
+		return ExecuteSSHCommandOutput(ctx, c, host, command)
+	}
+
+	if _, err := run("true"); err != nil {
+		return PreflightResults{{Host: host, Check: "ssh", Err: err}}
+	}
+
+	results := PreflightResults{{Host: host, Check: "ssh", Detail: "reachable"}}
+	for _, check := range []struct {
+		name string
+		fn   func(func(string) (string, error), *HostProfile) (string, error)
+	}{
+		{"escalation", checkEscalation},
+		{"nginx", checkNGINX},
+		{"keepalived", checkKeepalived},
+		{"sysctl", checkNonlocalBind},
+		{"paths", checkWritablePaths},
+	} {
+		detail, err := check.fn(run, profile)
+		results = append(results, PreflightResult{Host: host, Check: check.name, Detail: detail, Err: err})
+	}
+	return results
+}
+
+// checkEscalation checks that commands can be run through the privilege escalation wrapper
+// without a password prompt.
+func checkEscalation(run func(string) (string, error), profile *HostProfile) (string, error) {
+	if profile.Escalation == "" {
+		return "no escalation configured", nil
+	}
+	command := profile.Escalate("true")
+	if profile.Escalation == "sudo" {
+		// Fail instead of waiting for a password
+		command = "sudo -n true"
+	}
+	if _, err := run(command); err != nil {
+		return "", fmt.Errorf("cannot run commands through %s: %w", profile.Escalation, err)
+	}
+	return fmt.Sprintf("%s works", profile.Escalation), nil
+}
+
+// checkNGINX checks that NGINX is installed and has the stream module, built in or loaded.
+func checkNGINX(run func(string) (string, error), profile *HostProfile) (string, error) {
+	output, err := run(profile.Escalate("sh -c 'nginx -V 2>&1'"))
+	if err != nil {
+		return "", fmt.Errorf("nginx not found: %w", err)
+	}
+	version := "nginx"
+	if match := nginxVersionPattern.FindStringSubmatch(output); match != nil {
+		version = match[1]
+	}
+
+	switch {
+	case strings.Contains(output, "--with-stream=dynamic"):
+		loaded, err := run(profile.Escalate("sh -c 'nginx -T 2>/dev/null | grep -c \"^[[:space:]]*load_module.*ngx_stream_module\" || true'"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the NGINX config: %w", err)
+		}
+		if strings.TrimSpace(loaded) == "0" {
+			return "", fmt.Errorf("%s has the stream module as a dynamic module, but it is not loaded; add 'load_module modules/ngx_stream_module.so;' to %s", version, profile.NginxMainConfig)
+		}
+		return fmt.Sprintf("%s with the dynamic stream module loaded", version), nil
+	case strings.Contains(output, "--with-stream"):
+		return fmt.Sprintf("%s with the stream module", version), nil
+	default:
+		return "", fmt.Errorf("%s is built without the stream module", version)
+	}
+}
+
+// checkKeepalived checks that Keepalived is installed.
+func checkKeepalived(run func(string) (string, error), profile *HostProfile) (string, error) {
+	output, err := run(profile.Escalate("sh -c 'keepalived --version 2>&1'"))
+	if err != nil {
+		return "", fmt.Errorf("keepalived not found: %w", err)
+	}
+	if match := keepalivedVersionPattern.FindStringSubmatch(output); match != nil {
+		return fmt.Sprintf("keepalived %s", match[1]), nil
+	}
+	return "keepalived", nil
+}
+
+// checkNonlocalBind checks that NGINX can listen on VIPs the host does not hold, which BACKUP
+// hosts need for NGINX to start and reload.
+func checkNonlocalBind(run func(string) (string, error), _ *HostProfile) (string, error) {
+	output, err := run("sysctl -n net.ipv4.ip_nonlocal_bind")
+	if err != nil {
+		return "", fmt.Errorf("failed to read net.ipv4.ip_nonlocal_bind: %w", err)
+	}
+	if strings.TrimSpace(output) != "1" {
+		return "", fmt.Errorf("net.ipv4.ip_nonlocal_bind is %s, must be 1", strings.TrimSpace(output))
+	}
+	return "net.ipv4.ip_nonlocal_bind=1", nil
+}
+
+// checkWritablePaths checks that the config directories and main configs the operator writes
+// can be written, or created in the nearest existing parent directory.
+func checkWritablePaths(run func(string) (string, error), profile *HostProfile) (string, error) {
+	paths := []string{
+		profile.NginxClusterConfigDir(),
+		profile.NginxMainConfig,
+		profile.KeepalivedConfigDir,
+		profile.KeepalivedMainConfig,
+	}
+
+	var notWritable []string
+	for _, p := range paths {
+		check := profile.Escalate(fmt.Sprintf(`sh -c 'p=%s; while [ ! -e "$p" ]; do p=$(dirname "$p"); done; test -w "$p" && echo ok || true'`, path.Clean(p)))
+		output, err := run(check)
+		if err != nil {
+			return "", fmt.Errorf("failed to check %s: %w", p, err)
+		}
+		if strings.TrimSpace(output) != "ok" {
+			notWritable = append(notWritable, p)
+		}
+	}
+	if len(notWritable) > 0 {
+		return "", fmt.Errorf("not writable: %s", strings.Join(notWritable, ", "))
+	}
+	return fmt.Sprintf("%d paths writable", len(paths)), nil
+}
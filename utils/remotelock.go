@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// lockOwnerPattern matches lock owners that can be safely quoted in the stale lock check. The
+// owner is empty if its holder crashed before writing it.
+var lockOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9._-]*$`)
+
+// RemoteLock is a lock directory on an LB host, shared with the operators of other clusters
+// managing the same host. Creating a directory is atomic, so only one holder can succeed.
+type RemoteLock struct {
+	host  LBHost
+	path  string
+	owner string
+}
+
+// AcquireRemoteLock creates the lock directory at lockPath on the LB host, waiting while another
+// holder has it. A lock older than ttl is considered abandoned by a crashed holder and broken.
+func AcquireRemoteLock(ctx context.Context, c client.Client, host LBHost, lockPath string, ttl time.Duration) (*RemoteLock, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lock owner: %w", err)
+	}
+	lock := &RemoteLock{host: host, path: lockPath, owner: fmt.Sprintf("%s-%s", GetClusterName(), hex.EncodeToString(token))}
+
+	// Prints "acquired", or the current owner and the age of the lock in seconds
+	acquire := profile.Escalate(fmt.Sprintf(
+		`sh -c 'if mkdir %[1]s 2>/dev/null; then echo %[2]s > %[1]s/owner; echo acquired; else echo "$(cat %[1]s/owner 2>/dev/null) $(( $(date +%%s) - $(stat -c %%Y %[1]s) ))"; fi'`,
+		lockPath, lock.owner))
+
+	for {
+		output, err := ExecuteSSHCommandOutput(ctx, c, host, acquire)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lock %s on %s: %w", lockPath, host, err)
+		}
+		output = strings.TrimSpace(output)
+		if output == "acquired" {
+			return lock, nil
+		}
+
+		fields := strings.Fields(output)
+		if len(fields) > 0 {
+			staleOwner := strings.Join(fields[:len(fields)-1], " ")
+			if age, err := strconv.Atoi(fields[len(fields)-1]); err == nil && time.Duration(age)*time.Second > ttl && lockOwnerPattern.MatchString(staleOwner) {
+				// Fails if another waiter broke the lock first, in which case wait as usual
+				if err := ExecuteSSHCommand(ctx, c, host, profile.Escalate(breakStaleLockCommand(lockPath, staleOwner, ttl))); err == nil {
+					continue
+				}
+			}
+		}
+
+		if err := SleepWithContext(ctx, 2*time.Second); err != nil {
+			return nil, fmt.Errorf("timed out waiting for lock %s on %s held by %s: %w", lockPath, host, output, err)
+		}
+	}
+}
+
+// withRemoteLock runs fn while holding the lock directory at lockPath on the LB host. A lock that
+// cannot be released, e.g. because the host became unreachable, expires after GetRemoteLockTTL.
+func withRemoteLock(ctx context.Context, c client.Client, host LBHost, lockPath string, fn func() error) error {
+	lock, err := AcquireRemoteLock(ctx, c, host, lockPath, GetRemoteLockTTL())
+	if err != nil {
+		return err
+	}
+	defer lock.Release(ctx, c)
+	return fn()
+}
+
+// breakStaleLockCommand returns the command breaking the lock if it is still held by staleOwner and
+// older than ttl. Waiters breaking the lock are serialized with flock and the owner and age are
+// checked again under it, so a waiter that saw the same stale lock cannot break the fresh lock
+// another waiter has taken since; a fresh lock is never older than ttl, even before its owner is
+// written.
+func breakStaleLockCommand(lockPath, staleOwner string, ttl time.Duration) string {
+	return fmt.Sprintf(
+		`sh -c 'exec 9>%[1]s.break && flock 9 && [ -d %[1]s ] && [ "$(cat %[1]s/owner 2>/dev/null)" = "%[2]s" ] && [ $(( $(date +%%s) - $(stat -c %%Y %[1]s) )) -gt %[3]d ] && mv %[1]s %[1]s.stale.$$ && rm -rf %[1]s.stale.$$'`,
+		lockPath, staleOwner, int(ttl.Seconds()))
+}
+
+// Release removes the lock directory if it is still owned by this lock.
+func (l *RemoteLock) Release(ctx context.Context, c client.Client) error {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return err
+	}
+	release := profile.Escalate(fmt.Sprintf(
+		`sh -c 'if [ "$(cat %[1]s/owner 2>/dev/null)" = "%[2]s" ]; then rm -rf %[1]s; fi'`, l.path, l.owner))
+	return ExecuteSSHCommand(ctx, c, l.host, release)
+}
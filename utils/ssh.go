@@ -3,28 +3,29 @@ package utils
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"net"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// CopyFileToNGINXServer copies a file directly to the NGINX server via SSH and writes it using sudo.
-func CopyFileToNGINXServer(ctx context.Context, c client.Client, content, remotePath string) error {
-	clientConfig, err := GetSSHClientConfig(ctx, c)
+// CopyFileToNGINXServer copies a file directly to the LB host via SSH and writes it
+// using the privilege escalation from the host profile.
+func CopyFileToNGINXServer(ctx context.Context, c client.Client, host LBHost, content, remotePath string) error {
+	profile, err := LoadHostProfile(ctx, c)
 	if err != nil {
 		return err
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", clientConfig.Host), clientConfig.Config)
+	client, err := DialNGINXServer(ctx, c, host)
 	if err != nil {
-		return fmt.Errorf("failed to establish SSH connection: %w", err)
+		return err
 	}
 	defer client.Close()
 
@@ -37,27 +38,27 @@ func CopyFileToNGINXServer(ctx context.Context, c client.Client, content, remote
 	// Escape the content for use in the command
 	escapedContent := strings.ReplaceAll(content, "'", "'\\''")
 
-	// Command to echo the content and write it to the target file using sudo
-	command := fmt.Sprintf("echo '%s' | sudo tee %s", escapedContent, remotePath)
+	// Command to echo the content and write it to the target file with escalated privileges
+	command := fmt.Sprintf("echo '%s' | %s", escapedContent, profile.Escalate("tee "+remotePath))
 
-	if err := session.Run(command); err != nil {
+	if err := runSSHCommand(ctx, session, command); err != nil {
 		return fmt.Errorf("failed to write file to '%s': %w", remotePath, err)
 	}
 
 	return nil
 }
 
-// RemoveFileFromNGINXServer removes a file directly from the NGINX server via SSH using sudo.
-// It checks if the file exists before attempting to remove it.
-func RemoveFileFromNGINXServer(ctx context.Context, c client.Client, remotePath string) error {
-	clientConfig, err := GetSSHClientConfig(ctx, c)
+// RemoveFileFromNGINXServer removes a file directly from the LB host via SSH using the
+// privilege escalation from the host profile. It checks if the file exists before attempting to remove it.
+func RemoveFileFromNGINXServer(ctx context.Context, c client.Client, host LBHost, remotePath string) error {
+	profile, err := LoadHostProfile(ctx, c)
 	if err != nil {
 		return err
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", clientConfig.Host), clientConfig.Config)
+	client, err := DialNGINXServer(ctx, c, host)
 	if err != nil {
-		return fmt.Errorf("failed to establish SSH connection: %w", err)
+		return err
 	}
 	defer client.Close()
 
@@ -67,11 +68,11 @@ func RemoveFileFromNGINXServer(ctx context.Context, c client.Client, remotePath
 	}
 	defer session.Close()
 
-	// Check if the file exists
+	// Check if the file exists, escalated as its directory may only be readable by root
 	var output bytes.Buffer
 	session.Stdout = &output
-	checkFileCmd := fmt.Sprintf("[ -f %s ] && echo 'exists' || echo 'not_found'", remotePath)
-	if err := session.Run(checkFileCmd); err != nil {
+	checkFileCmd := profile.Escalate(fmt.Sprintf("sh -c '[ -f %s ] && echo exists || echo not_found'", remotePath))
+	if err := runSSHCommand(ctx, session, checkFileCmd); err != nil {
 		return fmt.Errorf("failed to check file existence at %s: %w", remotePath, err)
 	}
 
@@ -87,25 +88,20 @@ func RemoveFileFromNGINXServer(ctx context.Context, c client.Client, remotePath
 	}
 	defer session.Close()
 
-	command := fmt.Sprintf("sudo rm %s", remotePath)
-	if err := session.Run(command); err != nil {
+	command := profile.Escalate("rm " + remotePath)
+	if err := runSSHCommand(ctx, session, command); err != nil {
 		return fmt.Errorf("failed to remove file '%s': %w", remotePath, err)
 	}
 
 	return nil
 }
 
-// ExecuteSSHCommand executes a command on the NGINX server via SSH.
-func ExecuteSSHCommand(ctx context.Context, c client.Client, command string) error {
-	clientConfig, err := GetSSHClientConfig(ctx, c)
+// ExecuteSSHCommand executes a command on the LB host via SSH.
+func ExecuteSSHCommand(ctx context.Context, c client.Client, host LBHost, command string) error {
+	client, err := DialNGINXServer(ctx, c, host)
 	if err != nil {
 		return err
 	}
-
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", clientConfig.Host), clientConfig.Config)
-	if err != nil {
-		return fmt.Errorf("failed to establish SSH connection: %w", err)
-	}
 	defer client.Close()
 
 	session, err := client.NewSession()
@@ -115,93 +111,289 @@ func ExecuteSSHCommand(ctx context.Context, c client.Client, command string) err
 	defer session.Close()
 
 	// Run the command
-	if err := session.Run(command); err != nil {
+	if err := runSSHCommand(ctx, session, command); err != nil {
 		return fmt.Errorf("failed to execute command '%s': %w", command, err)
 	}
 
 	return nil
 }
 
-// GetSSHClientConfig retrieves SSH client configuration from the Kubernetes Secret.
-func GetSSHClientConfig(ctx context.Context, c client.Client) (*SSHClientConfig, error) {
-	secretName := os.Getenv("NGINX_CREDENTIALS_SECRET")
-	namespace := os.Getenv("NGINX_CREDENTIALS_NAMESPACE")
+// ExecuteSSHCommandOutput runs a command on the LB host via SSH and returns its standard output.
+func ExecuteSSHCommandOutput(ctx context.Context, c client.Client, host LBHost, command string) (string, error) {
+	client, err := DialNGINXServer(ctx, c, host)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
 
-	if secretName == "" || namespace == "" {
-		return nil, fmt.Errorf("NGINX_CREDENTIALS_SECRET and NGINX_CREDENTIALS_NAMESPACE must be set")
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create SSH session: %w", err)
 	}
+	defer session.Close()
 
-	secret := &corev1.Secret{}
-	if err := c.Get(ctx, types.NamespacedName{
-		Name:      secretName,
-		Namespace: namespace,
-	}, secret); err != nil {
-		return nil, fmt.Errorf("failed to get SSH credentials secret: %w", err)
+	var output bytes.Buffer
+	session.Stdout = &output
+	if err := runSSHCommand(ctx, session, command); err != nil {
+		return "", fmt.Errorf("failed to execute command '%s': %w", command, err)
 	}
 
-	nginxServerIP := string(secret.Data["NGINX_SERVER_IP"])
-	nginxUser := string(secret.Data["NGINX_USER"])
-	privateKey := secret.Data["NGINX_SSH_PRIVATE_KEY"]
-	knownHostsData := secret.Data["NGINX_KNOWN_HOSTS"]
+	return output.String(), nil
+}
 
-	if nginxServerIP == "" || nginxUser == "" || len(privateKey) == 0 || len(knownHostsData) == 0 {
-		return nil, fmt.Errorf("incomplete SSH credentials in secret")
+// DialNGINXServer establishes an SSH connection to the LB host using the active credentials.
+func DialNGINXServer(ctx context.Context, c client.Client, host LBHost) (*ssh.Client, error) {
+	clientConfig, err := GetSSHClientConfig(ctx, c, host)
+	if err != nil {
+		return nil, err
 	}
+	return dialSSH(ctx, clientConfig)
+}
 
-	signer, err := ssh.ParsePrivateKey(privateKey)
+// dialSSH connects to the host described by the client configuration. Connecting and the
+// SSH handshake are bounded by the dial timeout and aborted when the context is cancelled.
+func dialSSH(ctx context.Context, clientConfig *SSHClientConfig) (*ssh.Client, error) {
+	// The agent connection is only needed during the handshake
+	defer clientConfig.Close()
+
+	dialTimeout := GetSSHDialTimeout()
+	addr := net.JoinHostPort(clientConfig.Host, "22")
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
 	}
 
-	// Write known_hosts to a temp file
-	knownHostsFile, err := ioutil.TempFile("", "known_hosts")
+	if err := conn.SetDeadline(time.Now().Add(dialTimeout)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set SSH handshake deadline: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig.Config)
+	if !stop() {
+		// The context was cancelled and the connection closed during the handshake
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", ctx.Err())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file for known_hosts: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+
+	// Clear the handshake deadline; commands are bounded by runSSHCommand
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		sshConn.Close()
+		return nil, fmt.Errorf("failed to clear SSH handshake deadline: %w", err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// runSSHCommand runs a command in the session, bounded by the command timeout. If the context
+// is cancelled or the timeout expires, the remote process is killed and the session closed.
+func runSSHCommand(ctx context.Context, session *ssh.Session, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, GetSSHCommandTimeout())
+	defer cancel()
+
+	if err := session.Start(command); err != nil {
+		return err
 	}
-	defer os.Remove(knownHostsFile.Name())
 
-	if _, err := knownHostsFile.Write(knownHostsData); err != nil {
-		return nil, fmt.Errorf("failed to write known_hosts data: %w", err)
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return fmt.Errorf("command aborted: %w", ctx.Err())
 	}
-	knownHostsFile.Close()
+}
 
-	hostKeyCallback, err := knownhosts.New(knownHostsFile.Name())
+// GetSSHClientConfig builds the SSH client configuration for the LB host from the active credentials Secret.
+func GetSSHClientConfig(ctx context.Context, c client.Client, host LBHost) (*SSHClientConfig, error) {
+	secret, err := getActiveCredentials(ctx, c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create host key callback: %w", err)
+		return nil, err
+	}
+	return newSSHClientConfig(ctx, c, secret, host)
+}
+
+// newSSHClientConfig parses the credentials Secret into an SSH client configuration.
+// The authentication method is selected by the keys present in the Secret:
+//   - NGINX_SSH_PRIVATE_KEY, optionally encrypted with NGINX_SSH_PRIVATE_KEY_PASSPHRASE
+//   - NGINX_SSH_CERTIFICATE, a CA-signed user certificate for NGINX_SSH_PRIVATE_KEY
+//   - NGINX_SSH_AUTH_SOCK, the path of an ssh-agent socket mounted into the pod
+//
+// Host keys are verified against NGINX_KNOWN_HOSTS, or pinned on first use when
+// NGINX_HOST_KEY_MODE is set to "tofu".
+func newSSHClientConfig(ctx context.Context, c client.Client, secret *corev1.Secret, host LBHost) (*SSHClientConfig, error) {
+	nginxUser := string(secret.Data["NGINX_USER"])
+
+	if nginxUser == "" {
+		return nil, fmt.Errorf("incomplete SSH credentials in secret")
+	}
+
+	hostKeyCallback, err := getHostKeyCallback(ctx, c, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	authMethods, agentConn, err := getSSHAuthMethods(secret)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &ssh.ClientConfig{
-		User: nginxUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
+		User:            nginxUser,
+		Auth:            authMethods,
 		HostKeyCallback: hostKeyCallback,
 	}
 
 	return &SSHClientConfig{
-		Host:   nginxServerIP,
-		Config: config,
+		Host:      host.Address,
+		Config:    config,
+		agentConn: agentConn,
 	}, nil
 }
 
+// getSSHAuthMethods builds the SSH authentication methods from the credentials Secret.
+// The returned agent connection, if any, must be closed once the handshake is done.
+func getSSHAuthMethods(secret *corev1.Secret) ([]ssh.AuthMethod, net.Conn, error) {
+	privateKey := secret.Data["NGINX_SSH_PRIVATE_KEY"]
+	passphrase := secret.Data["NGINX_SSH_PRIVATE_KEY_PASSPHRASE"]
+	certificate := secret.Data["NGINX_SSH_CERTIFICATE"]
+	agentSocket := string(secret.Data["NGINX_SSH_AUTH_SOCK"])
+
+	if len(privateKey) == 0 && agentSocket == "" {
+		return nil, nil, fmt.Errorf("incomplete SSH credentials in secret: NGINX_SSH_PRIVATE_KEY or NGINX_SSH_AUTH_SOCK must be set")
+	}
+	if len(certificate) > 0 && len(privateKey) == 0 {
+		return nil, nil, fmt.Errorf("NGINX_SSH_CERTIFICATE requires NGINX_SSH_PRIVATE_KEY")
+	}
+
+	var signers []ssh.Signer
+
+	if len(privateKey) > 0 {
+		signer, err := parseSSHPrivateKey(privateKey, passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Prefer the certificate so the server can validate it against its trusted CA
+		if len(certificate) > 0 {
+			certSigner, err := newSSHCertSigner(certificate, signer)
+			if err != nil {
+				return nil, nil, err
+			}
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, signer)
+	}
+
+	var agentConn net.Conn
+	if agentSocket != "" {
+		conn, err := net.Dial("unix", agentSocket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", agentSocket, err)
+		}
+		agentSigners, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+		}
+		signers = append(signers, agentSigners...)
+		agentConn = conn
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, agentConn, nil
+}
+
+// parseSSHPrivateKey parses a private key, decrypting it with the passphrase when one is given.
+func parseSSHPrivateKey(privateKey, passphrase []byte) (ssh.Signer, error) {
+	if len(passphrase) > 0 {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			return nil, fmt.Errorf("private key is encrypted but NGINX_SSH_PRIVATE_KEY_PASSPHRASE is not set")
+		}
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// newSSHCertSigner combines a user certificate in authorized_keys format with its private key.
+func newSSHCertSigner(certificate []byte, signer ssh.Signer) (ssh.Signer, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH certificate: %w", err)
+	}
+
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("NGINX_SSH_CERTIFICATE does not contain an SSH certificate")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("NGINX_SSH_CERTIFICATE is not a user certificate")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("SSH certificate does not match private key: %w", err)
+	}
+	return certSigner, nil
+}
+
+// closeAgentConn closes the ssh-agent connection if one was opened.
+func closeAgentConn(conn net.Conn) {
+	if conn != nil {
+		conn.Close()
+	}
+}
+
 // SSHClientConfig holds the SSH client configuration details.
 type SSHClientConfig struct {
 	Host   string
 	Config *ssh.ClientConfig
+
+	agentConn net.Conn
 }
 
-// FetchFileFromNGINXServer retrieves the content of a file from the NGINX server via SSH.
+// Close releases the ssh-agent connection held by the configuration, if any.
+func (s *SSHClientConfig) Close() {
+	closeAgentConn(s.agentConn)
+}
+
+// FetchFileFromNGINXServer retrieves the content of a file from the LB host via SSH.
 // If the file does not exist, it returns an empty string, signaling no VRIDs have been allocated.
-func FetchFileFromNGINXServer(ctx context.Context, c client.Client, remotePath string) (string, error) {
-	clientConfig, err := GetSSHClientConfig(ctx, c)
+func FetchFileFromNGINXServer(ctx context.Context, c client.Client, host LBHost, remotePath string) (string, error) {
+	profile, err := LoadHostProfile(ctx, c)
 	if err != nil {
 		return "", err
 	}
 
 	// Establish SSH connection
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", clientConfig.Host), clientConfig.Config)
+	client, err := DialNGINXServer(ctx, c, host)
 	if err != nil {
-		return "", fmt.Errorf("failed to establish SSH connection: %w", err)
+		return "", err
 	}
 	defer client.Close()
 
@@ -212,11 +404,11 @@ func FetchFileFromNGINXServer(ctx context.Context, c client.Client, remotePath s
 	}
 	defer session.Close()
 
-	// Check if the file exists
+	// Check if the file exists, escalated as its directory may only be readable by root
 	var output bytes.Buffer
 	session.Stdout = &output
-	checkFileCmd := fmt.Sprintf("[ -f %s ] && echo 'exists' || echo 'not_found'", remotePath)
-	if err := session.Run(checkFileCmd); err != nil {
+	checkFileCmd := profile.Escalate(fmt.Sprintf("sh -c '[ -f %s ] && echo exists || echo not_found'", remotePath))
+	if err := runSSHCommand(ctx, session, checkFileCmd); err != nil {
 		return "", fmt.Errorf("failed to check file existence at %s: %w", remotePath, err)
 	}
 
@@ -234,8 +426,8 @@ func FetchFileFromNGINXServer(ctx context.Context, c client.Client, remotePath s
 
 	output.Reset()
 	session.Stdout = &output
-	command := fmt.Sprintf("sudo cat %s", remotePath)
-	if err := session.Run(command); err != nil {
+	command := profile.Escalate("cat " + remotePath)
+	if err := runSSHCommand(ctx, session, command); err != nil {
 		return "", fmt.Errorf("failed to fetch file from %s: %w", remotePath, err)
 	}
 
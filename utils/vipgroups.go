@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RebalanceAnnotation requests a one-off rebalance of the VIP groups when set to "true"
+// on the vip-group-assignments ConfigMap. It is removed once the rebalance is done.
+const RebalanceAnnotation = "nginx-lb-operator/rebalance"
+
+var (
+	vipGroupMutex sync.Mutex
+)
+
+// AssignVIPsToGroups returns the VIPs of each group. VIPs keep the group they were assigned
+// to before, new VIPs are placed in the least-loaded group, and VIPs only move between groups
+// when a rebalance is requested or the imbalance exceeds the rebalance threshold.
+// The assignments are persisted in the vip-group-assignments ConfigMap.
+func AssignVIPsToGroups(ctx context.Context, c client.Client, ips []string, groupCount int) ([][]string, error) {
+	vipGroupMutex.Lock()
+	defer vipGroupMutex.Unlock()
+
+	settings, err := LoadKeepalivedSettings(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = c.Get(ctx, client.ObjectKey{Name: "vip-group-assignments", Namespace: "nginx-lb-operator-system"}, configMap)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to load VIP group assignments: %w", err)
+	}
+	exists := err == nil
+
+	// Keep existing assignments of VIPs that are still allocated and whose group still exists
+	assignments := make(map[string]int)
+	for _, ip := range ips {
+		group, err := strconv.Atoi(configMap.Data[ip])
+		if err == nil && group >= 1 && group <= groupCount {
+			assignments[ip] = group - 1
+		}
+	}
+
+	groups := make([][]string, groupCount)
+	for i := range groups {
+		groups[i] = []string{}
+	}
+	for ip, group := range assignments {
+		groups[group] = append(groups[group], ip)
+	}
+
+	// Place new VIPs in the least-loaded group
+	sortedIPs := append([]string{}, ips...)
+	sort.Strings(sortedIPs)
+	for _, ip := range sortedIPs {
+		if _, assigned := assignments[ip]; assigned {
+			continue
+		}
+		group := leastLoadedGroup(groups)
+		groups[group] = append(groups[group], ip)
+	}
+
+	rebalanceRequested := configMap.Annotations[RebalanceAnnotation] == "true"
+	if rebalanceRequested || (settings.RebalanceThreshold > 0 && groupImbalance(groups) > settings.RebalanceThreshold) {
+		rebalanceGroups(groups)
+	}
+
+	for _, group := range groups {
+		sort.Strings(group)
+	}
+
+	// Persist the assignments if anything changed
+	data := make(map[string]string, len(ips))
+	for g, group := range groups {
+		for _, ip := range group {
+			data[ip] = strconv.Itoa(g + 1)
+		}
+	}
+	if !exists {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "vip-group-assignments",
+				Namespace: "nginx-lb-operator-system",
+			},
+			Data: data,
+		}
+		if err := c.Create(ctx, configMap); err != nil {
+			return nil, fmt.Errorf("failed to save VIP group assignments: %w", err)
+		}
+	} else if rebalanceRequested || !reflect.DeepEqual(configMap.Data, data) {
+		configMap.Data = data
+		delete(configMap.Annotations, RebalanceAnnotation)
+		if err := c.Update(ctx, configMap); err != nil {
+			return nil, fmt.Errorf("failed to save VIP group assignments: %w", err)
+		}
+	}
+
+	return groups, nil
+}
+
+// leastLoadedGroup returns the index of the group with the fewest VIPs, preferring lower indexes.
+func leastLoadedGroup(groups [][]string) int {
+	least := 0
+	for i, group := range groups {
+		if len(group) < len(groups[least]) {
+			least = i
+		}
+	}
+	return least
+}
+
+// mostLoadedGroup returns the index of the group with the most VIPs, preferring lower indexes.
+func mostLoadedGroup(groups [][]string) int {
+	most := 0
+	for i, group := range groups {
+		if len(group) > len(groups[most]) {
+			most = i
+		}
+	}
+	return most
+}
+
+// groupImbalance returns the difference in size between the largest and smallest group.
+func groupImbalance(groups [][]string) int {
+	return len(groups[mostLoadedGroup(groups)]) - len(groups[leastLoadedGroup(groups)])
+}
+
+// rebalanceGroups moves as few VIPs as possible from the largest to the smallest groups
+// until the group sizes differ by at most one.
+func rebalanceGroups(groups [][]string) {
+	for groupImbalance(groups) > 1 {
+		from := mostLoadedGroup(groups)
+		to := leastLoadedGroup(groups)
+
+		// Move the highest VIP so the choice is deterministic
+		sort.Strings(groups[from])
+		last := len(groups[from]) - 1
+		groups[to] = append(groups[to], groups[from][last])
+		groups[from] = groups[from][:last]
+	}
+}
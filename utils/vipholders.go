@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VIPHolderAnnotation is set on LoadBalancer Services to the LB host currently holding their VIP,
+// or "none" if no LB host holds it.
+const VIPHolderAnnotation = "nginx-lb-operator/vip-holder"
+
+// GetVIPHolders returns the LB hosts holding each of the given VIPs, as reported by `ip -o addr`
+// on every LB host. More than one holder means the hosts disagree on the VRRP MASTER. VIPs held by
+// no reachable host are absent; the statuses report which hosts could not be queried.
+func GetVIPHolders(ctx context.Context, c client.Client, vips []string) (map[string][]LBHost, HostApplyStatuses, error) {
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wanted := make(map[string]bool, len(vips))
+	for _, vip := range vips {
+		wanted[vip] = true
+	}
+
+	holders := make(map[string][]LBHost)
+	statuses := make(HostApplyStatuses, 0, len(hosts))
+	for _, host := range orderLBHostsForVRRP(hosts) {
+		output, err := ExecuteSSHCommandOutput(ctx, c, host, "ip -o addr show")
+		statuses = append(statuses, HostApplyStatus{Host: host, Err: err})
+		if err != nil {
+			continue
+		}
+		for _, address := range parseIPAddrOutput(output) {
+			if wanted[address] {
+				holders[address] = append(holders[address], host)
+			}
+		}
+	}
+
+	return holders, statuses, nil
+}
+
+// parseIPAddrOutput returns the addresses in the output of `ip -o addr show`, without prefix length.
+// Lines look like "2: eth0    inet 10.1.1.60/24 scope global secondary eth0 ...".
+func parseIPAddrOutput(output string) []string {
+	var addresses []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+		address, _, _ := strings.Cut(fields[3], "/")
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VIPConflictError is returned when a VIP, or the VIP and port a Service listens on, is already
+// owned by another cluster sharing the LB hosts.
+type VIPConflictError struct {
+	Listener string
+	Owner    string
+}
+
+func (e *VIPConflictError) Error() string {
+	return fmt.Sprintf("%s is already in use by cluster %s on the LB hosts", e.Listener, e.Owner)
+}
+
+// vipListenerOwner is the cluster and Service owning a VIP:port in the inventory.
+type vipListenerOwner struct {
+	Cluster string
+	Service string
+}
+
+// ClaimVIPListener records in the VIP inventory on the primary LB host that the Service listens
+// on ip and its port. It returns a VIPConflictError if another cluster owns the VIP.
+func ClaimVIPListener(ctx context.Context, c client.Client, service *corev1.Service, ip string) error {
+	clusterName := GetClusterName()
+	serviceKey := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	listener := net.JoinHostPort(ip, strconv.Itoa(int(service.Spec.Ports[0].Port)))
+
+	return updateVIPInventory(ctx, c, func(inventory map[string]vipListenerOwner) error {
+		for existing, owner := range inventory {
+			host, _, _ := net.SplitHostPort(existing)
+			if host == ip && owner.Cluster != clusterName {
+				return &VIPConflictError{Listener: existing, Owner: owner.Cluster}
+			}
+		}
+
+		// Drop the Service's previous listener in case its VIP or port changed
+		for existing, owner := range inventory {
+			if owner.Cluster == clusterName && owner.Service == serviceKey {
+				delete(inventory, existing)
+			}
+		}
+		inventory[listener] = vipListenerOwner{Cluster: clusterName, Service: serviceKey}
+		return nil
+	})
+}
+
+// ReleaseVIPListener removes the Service's listener from the VIP inventory.
+func ReleaseVIPListener(ctx context.Context, c client.Client, service *corev1.Service) error {
+	clusterName := GetClusterName()
+	serviceKey := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+
+	return updateVIPInventory(ctx, c, func(inventory map[string]vipListenerOwner) error {
+		for existing, owner := range inventory {
+			if owner.Cluster == clusterName && owner.Service == serviceKey {
+				delete(inventory, existing)
+			}
+		}
+		return nil
+	})
+}
+
+// releaseClusterVIPListeners removes all listeners of the cluster from the VIP inventory.
+func releaseClusterVIPListeners(ctx context.Context, c client.Client) error {
+	clusterName := GetClusterName()
+
+	return updateVIPInventory(ctx, c, func(inventory map[string]vipListenerOwner) error {
+		for existing, owner := range inventory {
+			if owner.Cluster == clusterName {
+				delete(inventory, existing)
+			}
+		}
+		return nil
+	})
+}
+
+// VIPsOfOtherClusters returns the VIPs other clusters own according to the VIP inventory.
+func VIPsOfOtherClusters(ctx context.Context, c client.Client) (map[string]string, error) {
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	inventoryPath, err := vipInventoryPath(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := fetchVIPInventory(ctx, c, host, inventoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterName := GetClusterName()
+	vips := make(map[string]string)
+	for listener, owner := range inventory {
+		if owner.Cluster == clusterName {
+			continue
+		}
+		if ip, _, err := net.SplitHostPort(listener); err == nil {
+			vips[ip] = owner.Cluster
+		}
+	}
+	return vips, nil
+}
+
+// updateVIPInventory applies update to the VIP inventory on the primary LB host under a lock,
+// and writes it back if update succeeds.
+func updateVIPInventory(ctx context.Context, c client.Client, update func(map[string]vipListenerOwner) error) error {
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return err
+	}
+	inventoryPath, err := vipInventoryPath(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return withRemoteLock(ctx, c, host, inventoryPath+".lock", func() error {
+		inventory, err := fetchVIPInventory(ctx, c, host, inventoryPath)
+		if err != nil {
+			return err
+		}
+		if err := update(inventory); err != nil {
+			return err
+		}
+
+		if err := CopyFileToNGINXServer(ctx, c, host, formatVIPInventory(inventory), inventoryPath); err != nil {
+			return fmt.Errorf("failed to update VIP inventory: %w", err)
+		}
+		return nil
+	})
+}
+
+// fetchVIPInventory reads the VIP inventory from the LB host. Lines look like
+// "10.1.1.200:443 <cluster> <namespace>/<name>".
+func fetchVIPInventory(ctx context.Context, c client.Client, host LBHost, inventoryPath string) (map[string]vipListenerOwner, error) {
+	content, err := FetchFileFromNGINXServer(ctx, c, host, inventoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch VIP inventory: %w", err)
+	}
+
+	inventory := make(map[string]vipListenerOwner)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		inventory[fields[0]] = vipListenerOwner{Cluster: fields[1], Service: fields[2]}
+	}
+	return inventory, nil
+}
+
+// formatVIPInventory formats the VIP inventory sorted by listener.
+func formatVIPInventory(inventory map[string]vipListenerOwner) string {
+	listeners := make([]string, 0, len(inventory))
+	for listener := range inventory {
+		listeners = append(listeners, listener)
+	}
+	sort.Strings(listeners)
+
+	var content strings.Builder
+	for _, listener := range listeners {
+		owner := inventory[listener]
+		content.WriteString(fmt.Sprintf("%s %s %s\n", listener, owner.Cluster, owner.Service))
+	}
+	return content.String()
+}
+
+// vipInventoryPath returns the path of the VIP inventory on the NGINX server.
+func vipInventoryPath(ctx context.Context, c client.Client) (string, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return profile.KeepalivedConfigPath("vip_inventory"), nil
+}
@@ -17,9 +17,9 @@ var (
 	vridAllocationMutex sync.Mutex
 )
 
-// GetOrAllocateVRIDs retrieves VRIDs for the cluster from the ConfigMap.
+// GetOrAllocateVRIDs retrieves VRIDs for the cluster from the ConfigMap, one per VIP group.
 // This function no longer handles VRID allocation.
-func GetOrAllocateVRIDs(ctx context.Context, c client.Client) (int, int, error) {
+func GetOrAllocateVRIDs(ctx context.Context, c client.Client) ([]int, error) {
 	vridAllocationMutex.Lock()
 	defer vridAllocationMutex.Unlock()
 
@@ -32,136 +32,224 @@ func GetOrAllocateVRIDs(ctx context.Context, c client.Client) (int, int, error)
 		Namespace: "nginx-lb-operator-system",
 	}, vridConfigMap)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get VRID allocations: %w", err)
+		return nil, fmt.Errorf("failed to get VRID allocations: %w", err)
 	}
 
 	// Check if VRIDs are allocated for this cluster
 	if vridStr, exists := vridConfigMap.Data[clusterName]; exists {
 		// VRIDs are already allocated
-		vrids := parseVRIDPair(vridStr)
+		vrids := parseVRIDs(vridStr)
 		if vrids != nil {
-			return vrids[0], vrids[1], nil
+			return vrids, nil
 		}
 	}
 
-	return 0, 0, fmt.Errorf("no VRIDs allocated for cluster %s", clusterName)
+	return nil, fmt.Errorf("no VRIDs allocated for cluster %s", clusterName)
 }
 
 // getAllocatedVRIDs returns a map of allocated VRIDs.
 func getAllocatedVRIDs(vridConfigMap *corev1.ConfigMap) map[int]bool {
-	allocated := make(map[int]bool)
-	for _, vridStr := range vridConfigMap.Data {
-		vrids := parseVRIDPair(vridStr)
-		if vrids != nil {
-			allocated[vrids[0]] = true
-			allocated[vrids[1]] = true
-		}
-	}
-	return allocated
+	return parseAllocatedVRIDs(vridConfigMap.Data)
 }
 
-// parseVRIDPair parses a string in the format "vrid1,vrid2".
-func parseVRIDPair(vridStr string) []int {
+// parseVRIDs parses a string in the format "vrid1,vrid2,...".
+func parseVRIDs(vridStr string) []int {
 	vridParts := strings.Split(vridStr, ",")
-	if len(vridParts) != 2 {
-		return nil
+	vrids := make([]int, 0, len(vridParts))
+	for _, part := range vridParts {
+		vrid, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || vrid < 1 || vrid > 255 {
+			return nil
+		}
+		vrids = append(vrids, vrid)
 	}
-	vrid1, err1 := strconv.Atoi(vridParts[0])
-	vrid2, err2 := strconv.Atoi(vridParts[1])
-	if err1 != nil || err2 != nil {
-		return nil
+	return vrids
+}
+
+// formatVRIDs formats VRIDs as "vrid1,vrid2,...".
+func formatVRIDs(vrids []int) string {
+	parts := make([]string, len(vrids))
+	for i, vrid := range vrids {
+		parts[i] = strconv.Itoa(vrid)
 	}
-	return []int{vrid1, vrid2}
+	return strings.Join(parts, ",")
 }
 
-// findUnusedVRIDPair finds an unused pair of VRIDs.
-func findUnusedVRIDPair(allocatedVRIDs map[int]bool) (int, int) {
+// findUnusedVRIDs finds count unused VRIDs, or returns nil if not enough are free.
+func findUnusedVRIDs(allocatedVRIDs map[int]bool, count int) []int {
 	maxVRID := 255 // VRID range is typically 1-255
-	for i := 1; i <= maxVRID-1; i += 2 {
-		if !allocatedVRIDs[i] && !allocatedVRIDs[i+1] {
-			return i, i + 1
+	vrids := []int{}
+	for i := 1; i <= maxVRID && len(vrids) < count; i++ {
+		if !allocatedVRIDs[i] {
+			vrids = append(vrids, i)
 		}
 	}
-	return 0, 0
+	if len(vrids) < count {
+		return nil
+	}
+	return vrids
+}
+
+// resizeVRIDs keeps the first VRIDs of an existing allocation and allocates additional
+// unused VRIDs until there is one per VIP group.
+func resizeVRIDs(existing []int, allocatedVRIDs map[int]bool, count int) []int {
+	if len(existing) >= count {
+		return existing[:count]
+	}
+	additional := findUnusedVRIDs(allocatedVRIDs, count-len(existing))
+	if additional == nil {
+		return nil
+	}
+	return append(append([]int{}, existing...), additional...)
 }
 
-// UpdateVRIDAllocationsFile updates the VRID_allocations.conf on the NGINX server.
+// UpdateVRIDAllocationsFile updates the VRID_allocations.conf on the primary LB host.
 func UpdateVRIDAllocationsFile(ctx context.Context, c client.Client, vridData map[string]string) error {
 	content := ""
 	for clusterName, vridStr := range vridData {
 		content += fmt.Sprintf("%s: %s\n", clusterName, vridStr)
 	}
-	remotePath := "/etc/keepalived/VRID_allocations.conf"
-	if err := CopyFileToNGINXServer(ctx, c, content, remotePath); err != nil {
+	remotePath, err := vridAllocationsPath(ctx, c)
+	if err != nil {
+		return err
+	}
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := CopyFileToNGINXServer(ctx, c, host, content, remotePath); err != nil {
 		return fmt.Errorf("failed to update VRID_allocations.conf: %w", err)
 	}
 	return nil
 }
 
-// GetOrAllocateVRIDsOnStartup handles VRID allocation at operator startup.
+// GetOrAllocateVRIDsOnStartup handles VRID allocation at operator startup, and again when the
+// number of VIP groups changes. One VRID is allocated per VIP group. The read-modify-write of VRID_allocations.conf is done
+// under a lock on the primary LB host, so operators of other clusters sharing the host cannot
+// claim the same VRIDs, and VRIDs used by any Keepalived config on the LB hosts are avoided.
 func GetOrAllocateVRIDsOnStartup(ctx context.Context, c client.Client) error {
 	vridAllocationMutex.Lock()
 	defer vridAllocationMutex.Unlock()
 
+	// Never take over the files of another cluster using the same name
+	if err := VerifyClusterIdentity(ctx, c); err != nil {
+		return err
+	}
+
 	clusterName := GetClusterName()
 
-	// Step 1: Fetch VRID_allocations.conf from the NGINX server
+	groupCount, err := GetVIPGroupCount(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return err
+	}
+	lockPath, err := vridLockPath(ctx, c)
+	if err != nil {
+		return err
+	}
+	return withRemoteLock(ctx, c, host, lockPath, func() error {
+		return allocateClusterVRIDs(ctx, c, clusterName, groupCount)
+	})
+}
+
+// allocateClusterVRIDs keeps the cluster's VRIDs in VRID_allocations.conf that do not clash with
+// another cluster's, allocates one for every other VIP group, and records them in the file and
+// the ConfigMap. It must be called under the VRID lock.
+func allocateClusterVRIDs(ctx context.Context, c client.Client, clusterName string, groupCount int) error {
+	// Step 1: Fetch VRID_allocations.conf from the NGINX server; it is empty if it does not exist yet
 	vridAllocationsData, err := FetchVRIDAllocationsFromNGINX(ctx, c)
 	if err != nil {
 		return fmt.Errorf("failed to fetch VRID_allocations.conf from NGINX: %w", err)
 	}
 
-	// If VRID_allocations.conf does not exist (empty data), treat it as a new allocation
-	if len(vridAllocationsData) == 0 {
-		// Allocate new VRIDs for the cluster
-		vrid1, vrid2 := 1, 2
-		vridAllocationsData = map[string]string{
-			clusterName: fmt.Sprintf("%d,%d", vrid1, vrid2),
+	// Step 2: Collect the VRIDs of other clusters, including ones only found in Keepalived configs
+	inUse, err := scanVirtualRouterIDs(ctx, c)
+	if err != nil {
+		return err
+	}
+	allocatedVRIDs := parseAllocatedVRIDs(vridAllocationsData)
+	for vrid := range inUse {
+		allocatedVRIDs[vrid] = true
+	}
+
+	// Step 3: Keep the cluster's VRIDs that do not clash, and allocate one for every other VIP group
+	existing := []int{}
+	for _, vrid := range parseVRIDs(vridAllocationsData[clusterName]) {
+		if !inUse[vrid] {
+			existing = append(existing, vrid)
 		}
+	}
+	vrids := resizeVRIDs(existing, allocatedVRIDs, groupCount)
+	if vrids == nil {
+		return fmt.Errorf("no available VRIDs")
+	}
 
-		// Create the VRID_allocations.conf file on NGINX
-		fileContent := createVRIDFileContent(vridAllocationsData)
-		if err := CopyFileToNGINXServer(ctx, c, fileContent, "/etc/keepalived/VRID_allocations.conf"); err != nil {
-			return fmt.Errorf("failed to create VRID_allocations.conf on NGINX: %w", err)
+	// Step 4: Update the VRID_allocations.conf file on the NGINX server and verify the write
+	if vridAllocationsData[clusterName] != formatVRIDs(vrids) {
+		vridAllocationsData[clusterName] = formatVRIDs(vrids)
+		if err := UpdateVRIDAllocationsFile(ctx, c, vridAllocationsData); err != nil {
+			return fmt.Errorf("failed to update VRID_allocations.conf: %w", err)
 		}
 
-		// Only update the ConfigMap with the operator's VRIDs
-		if err := updateConfigMapWithClusterVRID(ctx, c, clusterName, fmt.Sprintf("%d,%d", vrid1, vrid2)); err != nil {
-			return fmt.Errorf("failed to update VRID allocations ConfigMap: %w", err)
+		written, err := FetchVRIDAllocationsFromNGINX(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to verify VRID_allocations.conf: %w", err)
+		}
+		if written[clusterName] != formatVRIDs(vrids) {
+			return fmt.Errorf("VRID_allocations.conf was changed concurrently: expected VRIDs %s for cluster %s, found '%s'",
+				formatVRIDs(vrids), clusterName, written[clusterName])
 		}
+	}
 
-		return nil
+	// Only update the ConfigMap with the operator's VRIDs
+	if err := updateConfigMapWithClusterVRID(ctx, c, clusterName, formatVRIDs(vrids)); err != nil {
+		return fmt.Errorf("failed to update VRID allocations ConfigMap: %w", err)
 	}
 
-	// Step 2: Check if the current cluster has VRIDs in the file
-	if _, exists := vridAllocationsData[clusterName]; !exists {
-		// Allocate new VRIDs for the current cluster
-		allocatedVRIDs := parseAllocatedVRIDs(vridAllocationsData)
-		vrid1, vrid2 := findUnusedVRIDPair(allocatedVRIDs)
-		if vrid1 == 0 || vrid2 == 0 {
-			return fmt.Errorf("no available VRIDs")
-		}
+	return nil
+}
 
-		// Add the new VRIDs to the VRID_allocations.conf
-		vridAllocationsData[clusterName] = fmt.Sprintf("%d,%d", vrid1, vrid2)
+// scanVirtualRouterIDs returns the VRIDs used by the Keepalived configs of other clusters on all
+// LB hosts, found by scanning every *.conf in the Keepalived config directory.
+func scanVirtualRouterIDs(ctx context.Context, c client.Client) (map[int]bool, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
-		// Update the VRID_allocations.conf file on the NGINX server
-		if err := UpdateVRIDAllocationsFile(ctx, c, vridAllocationsData); err != nil {
-			return fmt.Errorf("failed to update VRID_allocations.conf: %w", err)
-		}
+	ownConfig := profile.KeepalivedConfigPath(fmt.Sprintf("%s_keepalived.conf", GetClusterName()))
+	command := profile.Escalate(fmt.Sprintf("sh -c 'grep -Hs virtual_router_id %s/*.conf || true'", profile.KeepalivedConfigDir))
 
-		// Only update the ConfigMap with the operator's VRIDs
-		if err := updateConfigMapWithClusterVRID(ctx, c, clusterName, fmt.Sprintf("%d,%d", vrid1, vrid2)); err != nil {
-			return fmt.Errorf("failed to update VRID allocations ConfigMap: %w", err)
+	inUse := make(map[int]bool)
+	for _, host := range hosts {
+		output, err := ExecuteSSHCommandOutput(ctx, c, host, command)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Keepalived configs on %s: %w", host, err)
 		}
-	} else {
-		// If the current cluster already has VRIDs, ensure the ConfigMap reflects that
-		if err := updateConfigMapWithClusterVRID(ctx, c, clusterName, vridAllocationsData[clusterName]); err != nil {
-			return fmt.Errorf("failed to update VRID allocations ConfigMap: %w", err)
+		// Lines look like "/etc/keepalived/other_keepalived.conf:    virtual_router_id 3"
+		for _, line := range strings.Split(output, "\n") {
+			path, setting, found := strings.Cut(line, ":")
+			if !found || path == ownConfig {
+				continue
+			}
+			fields := strings.Fields(setting)
+			if len(fields) < 2 || fields[0] != "virtual_router_id" {
+				continue
+			}
+			if vrid, err := strconv.Atoi(fields[1]); err == nil {
+				inUse[vrid] = true
+			}
 		}
 	}
-
-	return nil
+	return inUse, nil
 }
 
 // updateConfigMapWithClusterVRID updates the ConfigMap with only the operator's cluster VRID allocation.
@@ -225,9 +313,35 @@ func createVRIDFileContent(vridData map[string]string) string {
 	return content.String()
 }
 
-// FetchVRIDAllocationsFromNGINX fetches the VRID_allocations.conf from the NGINX server.
+// vridAllocationsPath returns the path of VRID_allocations.conf on the NGINX server.
+func vridAllocationsPath(ctx context.Context, c client.Client) (string, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return profile.KeepalivedConfigPath("VRID_allocations.conf"), nil
+}
+
+// vridLockPath returns the path of the lock guarding VRID_allocations.conf on the NGINX server.
+func vridLockPath(ctx context.Context, c client.Client) (string, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return profile.KeepalivedConfigPath("VRID_allocations.lock"), nil
+}
+
+// FetchVRIDAllocationsFromNGINX fetches the VRID_allocations.conf from the primary LB host.
 func FetchVRIDAllocationsFromNGINX(ctx context.Context, c client.Client) (map[string]string, error) {
-	content, err := FetchFileFromNGINXServer(ctx, c, "/etc/keepalived/VRID_allocations.conf")
+	remotePath, err := vridAllocationsPath(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	host, err := GetPrimaryLBHost(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	content, err := FetchFileFromNGINXServer(ctx, c, host, remotePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch VRID_allocations.conf: %w", err)
 	}
@@ -253,10 +367,8 @@ func FetchVRIDAllocationsFromNGINX(ctx context.Context, c client.Client) (map[st
 func parseAllocatedVRIDs(vridData map[string]string) map[int]bool {
 	allocated := make(map[int]bool)
 	for _, vridStr := range vridData {
-		vrids := parseVRIDPair(vridStr)
-		if vrids != nil {
-			allocated[vrids[0]] = true
-			allocated[vrids[1]] = true
+		for _, vrid := range parseVRIDs(vridStr) {
+			allocated[vrid] = true
 		}
 	}
 	return allocated
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// VRRPAuthTypePASS authenticates VRRP adverts with a plain-text password.
+	VRRPAuthTypePASS = "PASS"
+	// VRRPAuthTypeAH authenticates VRRP adverts with an IPsec AH header keyed by the password.
+	VRRPAuthTypeAH = "AH"
+
+	// RotateAnnotation requests a new generated auth pass when set to "true" on the VRRP auth Secret.
+	// It is removed once the new auth pass is stored.
+	RotateAnnotation = "nginx-lb-operator/rotate"
+
+	// vrrpAuthPassLength is the longest password Keepalived uses; longer passwords are truncated.
+	vrrpAuthPassLength = 8
+	vrrpAuthPassChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	vrrpAuthMutex sync.Mutex
+)
+
+// VRRPAuth is the authentication shared by all VRRP instances of the cluster.
+type VRRPAuth struct {
+	Type string
+	Pass string
+}
+
+// GetVRRPAuthSecretName returns the name of the Secret holding the generated VRRP auth pass.
+func GetVRRPAuthSecretName() string {
+	return GetEnv("KEEPALIVED_AUTH_SECRET", "keepalived-auth")
+}
+
+// GetOrCreateVRRPAuth returns the VRRP authentication for the cluster. The auth pass is read from
+// the VRRP auth Secret, which is created with a random auth pass if missing, and regenerated when
+// the rotate annotation is set. KEEPALIVED_AUTH_PASS overrides the Secret.
+func GetOrCreateVRRPAuth(ctx context.Context, c client.Client) (*VRRPAuth, error) {
+	vrrpAuthMutex.Lock()
+	defer vrrpAuthMutex.Unlock()
+
+	settings, err := LoadKeepalivedSettings(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	auth := &VRRPAuth{Type: settings.AuthType}
+
+	if authPass := os.Getenv("KEEPALIVED_AUTH_PASS"); authPass != "" {
+		auth.Pass = authPass
+		return auth, nil
+	}
+
+	// Read uncached, so a rotation is neither missed nor repeated on a stale Secret
+	secret := &corev1.Secret{}
+	err = uncachedReader(c).Get(ctx, client.ObjectKey{Name: GetVRRPAuthSecretName(), Namespace: "nginx-lb-operator-system"}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to load VRRP auth secret: %w", err)
+	}
+
+	if errors.IsNotFound(err) {
+		authPass, err := generateVRRPAuthPass()
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      GetVRRPAuthSecretName(),
+				Namespace: "nginx-lb-operator-system",
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				"auth_pass": []byte(authPass),
+			},
+		}
+		if err := c.Create(ctx, secret); err != nil {
+			return nil, fmt.Errorf("failed to create VRRP auth secret: %w", err)
+		}
+		auth.Pass = authPass
+		return auth, nil
+	}
+
+	authPass := strings.TrimSpace(string(secret.Data["auth_pass"]))
+	if authPass == "" || secret.Annotations[RotateAnnotation] == "true" {
+		if authPass, err = generateVRRPAuthPass(); err != nil {
+			return nil, err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data["auth_pass"] = []byte(authPass)
+		delete(secret.Annotations, RotateAnnotation)
+		if err := c.Update(ctx, secret); err != nil {
+			return nil, fmt.Errorf("failed to rotate VRRP auth pass: %w", err)
+		}
+	}
+
+	auth.Pass = authPass
+	return auth, nil
+}
+
+// generateVRRPAuthPass returns a random alphanumeric auth pass.
+func generateVRRPAuthPass() (string, error) {
+	var pass strings.Builder
+	charCount := big.NewInt(int64(len(vrrpAuthPassChars)))
+	for i := 0; i < vrrpAuthPassLength; i++ {
+		n, err := rand.Int(rand.Reader, charCount)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate VRRP auth pass: %w", err)
+		}
+		pass.WriteByte(vrrpAuthPassChars[n.Int64()])
+	}
+	return pass.String(), nil
+}
+
+// VRRPAuthApplied reports whether the Keepalived configuration on every LB host already uses auth.
+// Hosts without a cluster config yet are skipped, as the auth is applied with their first config.
+func VRRPAuthApplied(ctx context.Context, c client.Client, auth *VRRPAuth) (bool, error) {
+	profile, err := LoadHostProfile(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	hosts, err := GetLBHosts(ctx, c)
+	if err != nil {
+		return false, err
+	}
+
+	remotePath := clusterKeepalivedConfigPath(profile)
+	for _, host := range hosts {
+		config, err := FetchFileFromNGINXServer(ctx, c, host, remotePath)
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch Keepalived config from %s: %w", host, err)
+		}
+		if config == "" {
+			continue
+		}
+		if !strings.Contains(config, "auth_type "+auth.Type+"\n") || !strings.Contains(config, "auth_pass "+auth.Pass+"\n") {
+			return false, nil
+		}
+	}
+	return true, nil
+}